@@ -2,7 +2,6 @@ package main
 
 import (
 	"errors"
-	"io/ioutil"
 	"regexp"
 	"strings"
 
@@ -38,13 +37,22 @@ func (a Agent) Version() string {
 	return v
 }
 
+func (a Agent) DiagnosticFormat() string {
+	return "gcc"
+}
+
 func (a Agent) Test() (map[string]string, string, string) {
 	return map[string]string{"test/hello.cpp": ""}, "", "Hello World"
 }
 
 func (a Agent) ActionCommands(in sango.Input) (map[string][]string, error) {
 	return map[string][]string{
-		"fmt": append([]string{"goimports", "-w"}, sango.MapToFileList(in.Files)...),
+		"fmt": append([]string{
+			"clang-format",
+			"-i",
+			"-style=" + style(in),
+		}, sango.SourceFiles(in, sango.Extensions("h", "hpp")...)...),
+		"lint": append(append([]string{"cppcheck"}, sango.CppcheckArgs...), sango.SourceFiles(in, sango.Extensions()...)...),
 	}, nil
 }
 
@@ -54,20 +62,24 @@ func (a Agent) Action(c string, in sango.Input) (sango.ExecResult, error) {
 		if err != nil {
 			return sango.ExecResult{}, err
 		}
-		r, err := sango.Jtime(a["fmt"], "fmt", in, nil)
-		files := map[string]string{}
-		for k := range in.Files {
-			data, err := ioutil.ReadFile(k)
-			if err == nil {
-				files[k] = string(data)
-			}
+		return sango.Format("fmt", a["fmt"], in)
+	} else if c == "lint" {
+		a, err := a.ActionCommands(in)
+		if err != nil {
+			return sango.ExecResult{}, err
 		}
-		r.Data = files
-		return r, err
+		return sango.Lint("lint", a["lint"], "cppcheck", in)
 	}
 	return sango.ExecResult{}, errors.New("unknown command")
 }
 
+func style(in sango.Input) string {
+	if s, ok := in.Options["style"].(string); ok {
+		return s
+	}
+	return "LLVM"
+}
+
 func main() {
 	sango.Run(Agent{})
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/h2so5/sango/src"
@@ -23,7 +24,7 @@ func (a Agent) BuildCommand(in sango.Input) ([]string, error) {
 		}
 	}
 
-	return append([]string{"go"}, append(args, sango.MapToFileList(in.Files)...)...), nil
+	return append([]string{"go"}, append(args, sango.SourceFiles(in, sango.Extensions()...)...)...), nil
 }
 
 func (a Agent) RunCommand(in sango.Input) ([]string, error) {
@@ -36,10 +37,33 @@ func (a Agent) Version() string {
 	return v
 }
 
+func (a Agent) DiagnosticFormat() string {
+	return "go"
+}
+
 func (a Agent) Test() (map[string]string, string, string) {
 	return map[string]string{"test/hello.go": ""}, "", "Hello World"
 }
 
+// go vet can't be installed for Go 1.2, so lint reports the syntax errors
+// found by gofmt.
+func (a Agent) ActionCommands(in sango.Input) (map[string][]string, error) {
+	return map[string][]string{
+		"lint": append([]string{"gofmt", "-e", "-l"}, sango.SourceFiles(in, sango.Extensions()...)...),
+	}, nil
+}
+
+func (a Agent) Action(c string, in sango.Input) (sango.ExecResult, error) {
+	if c == "lint" {
+		a, err := a.ActionCommands(in)
+		if err != nil {
+			return sango.ExecResult{}, err
+		}
+		return sango.Lint("lint", a["lint"], "go", in)
+	}
+	return sango.ExecResult{}, errors.New("unknown command")
+}
+
 func main() {
 	sango.Run(Agent{})
 }
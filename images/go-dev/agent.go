@@ -25,7 +25,7 @@ func (a Agent) BuildCommand(in sango.Input) ([]string, error) {
 		}
 	}
 
-	return append([]string{"go"}, append(args, sango.MapToFileList(in.Files)...)...), nil
+	return append([]string{"go"}, append(args, sango.SourceFiles(in, sango.Extensions()...)...)...), nil
 }
 
 func (a Agent) RunCommand(in sango.Input) ([]string, error) {
@@ -38,13 +38,18 @@ func (a Agent) Version() string {
 	return v
 }
 
+func (a Agent) DiagnosticFormat() string {
+	return "go"
+}
+
 func (a Agent) Test() (map[string]string, string, string) {
 	return map[string]string{"test/hello.go": ""}, "", "Hello World"
 }
 
 func (a Agent) ActionCommands(in sango.Input) (map[string][]string, error) {
 	return map[string][]string{
-		"fmt": append([]string{"goimports", "-w"}, sango.MapToFileList(in.Files)...),
+		"fmt":  append([]string{"goimports", "-w"}, sango.SourceFiles(in, sango.Extensions()...)...),
+		"lint": append([]string{"go", "vet"}, sango.SourceFiles(in, sango.Extensions()...)...),
 	}, nil
 }
 
@@ -64,6 +69,12 @@ func (a Agent) Action(c string, in sango.Input) (sango.ExecResult, error) {
 		}
 		r.Data = files
 		return r, err
+	} else if c == "lint" {
+		a, err := a.ActionCommands(in)
+		if err != nil {
+			return sango.ExecResult{}, err
+		}
+		return sango.Lint("lint", a["lint"], "govet", in)
 	}
 	return sango.ExecResult{}, errors.New("unknown command")
 }
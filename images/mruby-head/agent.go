@@ -1,13 +1,17 @@
 package main
 
-import "github.com/h2so5/sango/src"
+import (
+	"errors"
+
+	"github.com/h2so5/sango/src"
+)
 
 type Agent struct {
 	sango.AgentBase
 }
 
 func (a Agent) RunCommand(in sango.Input) ([]string, error) {
-	return append([]string{"mruby"}, sango.MapToFileList(in.Files)...), nil
+	return []string{"mruby", sango.MainFile(in, sango.Extensions()...)}, nil
 }
 
 func (a Agent) Version() string {
@@ -16,10 +20,31 @@ func (a Agent) Version() string {
 	return v[:len(v)-1] + g[:8]
 }
 
+func (a Agent) DiagnosticFormat() string {
+	return "mruby"
+}
+
 func (a Agent) Test() (map[string]string, string, string) {
 	return map[string]string{"test/hello.rb": ""}, "", "Hello World"
 }
 
+func (a Agent) ActionCommands(in sango.Input) (map[string][]string, error) {
+	return map[string][]string{
+		"lint": append([]string{"sh", "-c", `r=0; for f; do mruby -c "$f" || r=1; done; exit $r`, "lint"}, sango.SourceFiles(in, sango.Extensions()...)...),
+	}, nil
+}
+
+func (a Agent) Action(c string, in sango.Input) (sango.ExecResult, error) {
+	if c == "lint" {
+		a, err := a.ActionCommands(in)
+		if err != nil {
+			return sango.ExecResult{}, err
+		}
+		return sango.Lint("lint", a["lint"], "mruby", in)
+	}
+	return sango.ExecResult{}, errors.New("unknown command")
+}
+
 func main() {
 	sango.Run(Agent{})
 }
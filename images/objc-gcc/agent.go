@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"regexp"
 	"strings"
 
@@ -25,7 +26,7 @@ func (a Agent) BuildCommand(in sango.Input) ([]string, error) {
 	v = strings.Replace(v, "\n", "", -1)
 	args = append(args, strings.Split(v, " ")...)
 
-	args = append(args, sango.MapToFileList(in.Files)...)
+	args = append(args, sango.SourceFiles(in, sango.Extensions()...)...)
 	args = append(args, "-lgnustep-base")
 
 	v, _ = sango.System(".", "", "gnustep-config", "--objc-libs")
@@ -47,10 +48,49 @@ func (a Agent) Version() string {
 	return v
 }
 
+func (a Agent) DiagnosticFormat() string {
+	return "gcc"
+}
+
 func (a Agent) Test() (map[string]string, string, string) {
 	return map[string]string{"test/hello.m": ""}, "", "Hello World"
 }
 
+func (a Agent) ActionCommands(in sango.Input) (map[string][]string, error) {
+	b, err := a.BuildCommand(in)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]string{
+		"fmt":  append([]string{"clang-format", "-i", "-style=" + style(in)}, sango.SourceFiles(in, sango.Extensions("h")...)...),
+		"lint": append(b, "-fsyntax-only", "-Wall", "-Wextra"),
+	}, nil
+}
+
+func (a Agent) Action(c string, in sango.Input) (sango.ExecResult, error) {
+	if c == "fmt" {
+		a, err := a.ActionCommands(in)
+		if err != nil {
+			return sango.ExecResult{}, err
+		}
+		return sango.Format("fmt", a["fmt"], in)
+	} else if c == "lint" {
+		a, err := a.ActionCommands(in)
+		if err != nil {
+			return sango.ExecResult{}, err
+		}
+		return sango.Lint("lint", a["lint"], "gcc", in)
+	}
+	return sango.ExecResult{}, errors.New("unknown command")
+}
+
+func style(in sango.Input) string {
+	if s, ok := in.Options["style"].(string); ok {
+		return s
+	}
+	return "LLVM"
+}
+
 func main() {
 	sango.Run(Agent{})
 }
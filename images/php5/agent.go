@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"regexp"
 	"strings"
 
@@ -14,7 +15,7 @@ type Agent struct {
 }
 
 func (a Agent) RunCommand(in sango.Input) ([]string, error) {
-	return append([]string{"php"}, sango.MapToFileList(in.Files)...), nil
+	return []string{"php", sango.MainFile(in, sango.Extensions()...)}, nil
 }
 
 func (a Agent) Version() string {
@@ -24,10 +25,32 @@ func (a Agent) Version() string {
 	return v
 }
 
+func (a Agent) DiagnosticFormat() string {
+	return "php"
+}
+
 func (a Agent) Test() (map[string]string, string, string) {
 	return map[string]string{"test/hello.php": ""}, "", "Hello World"
 }
 
+func (a Agent) ActionCommands(in sango.Input) (map[string][]string, error) {
+	// php -l checks only one file at a time.
+	return map[string][]string{
+		"lint": append([]string{"sh", "-c", `r=0; for f; do php -l "$f" || r=1; done; exit $r`, "lint"}, sango.SourceFiles(in, sango.Extensions()...)...),
+	}, nil
+}
+
+func (a Agent) Action(c string, in sango.Input) (sango.ExecResult, error) {
+	if c == "lint" {
+		a, err := a.ActionCommands(in)
+		if err != nil {
+			return sango.ExecResult{}, err
+		}
+		return sango.Lint("lint", a["lint"], "php", in)
+	}
+	return sango.ExecResult{}, errors.New("unknown command")
+}
+
 func main() {
 	sango.Run(Agent{})
 }
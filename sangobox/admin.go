@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/h2so5/sango/src"
+	"github.com/h2so5/sango/store"
+)
+
+func (s *Sango) requireAdmin(r render.Render, res http.ResponseWriter, req *http.Request) {
+	if !hasToken(req, s.conf.AdminTokens) {
+		res.Header().Set("WWW-Authenticate", "Bearer")
+		r.JSON(401, map[string]string{"error": "Unauthorized"})
+	}
+}
+
+// rescan rebuilds the image list. The disabled environments are reloaded
+// from the store as well so that every node picks them up.
+func (s *Sango) rescan(pull bool) ([]sango.ImageCandidate, error) {
+	s.scanMutex.Lock()
+	defer s.scanMutex.Unlock()
+
+	images, candidates, err := sango.ScanImages(s.conf.ImageDir, pull)
+	if err != nil {
+		return nil, err
+	}
+
+	disabled, err := s.loadDisabled()
+	s.adminMutex.Lock()
+	s.candidates = candidates
+	if err == nil || err == store.ErrNotFound {
+		s.disabled = disabled
+	} else {
+		log.Print(err)
+	}
+	s.adminMutex.Unlock()
+
+	s.imgupdate <- images
+	return candidates, nil
+}
+
+func (s *Sango) loadDisabled() (map[string]bool, error) {
+	m := make(map[string]bool)
+	data, err := s.db.Get("disabled")
+	if err != nil {
+		return m, err
+	}
+	var l []string
+	err = msgpack.Unmarshal(data, &l)
+	if err != nil {
+		return m, err
+	}
+	for _, id := range l {
+		m[id] = true
+	}
+	return m, nil
+}
+
+func (s *Sango) disabledList() []string {
+	s.adminMutex.Lock()
+	defer s.adminMutex.Unlock()
+	l := make([]string, 0, len(s.disabled))
+	for id := range s.disabled {
+		l = append(l, id)
+	}
+	sort.Strings(l)
+	return l
+}
+
+func (s *Sango) apiAdminImages(r render.Render) {
+	s.adminMutex.Lock()
+	candidates := s.candidates
+	s.adminMutex.Unlock()
+
+	var updated interface{}
+	if n := atomic.LoadInt64(&s.imagesUpdated); n != 0 {
+		updated = time.Unix(0, n)
+	}
+	r.JSON(200, map[string]interface{}{
+		"candidates": candidates,
+		"disabled":   s.disabledList(),
+		"updated":    updated,
+	})
+}
+
+func (s *Sango) apiAdminRescan(r render.Render, req *http.Request) {
+	pull := req.URL.Query().Get("pull")
+	candidates, err := s.rescan(pull == "1" || pull == "true")
+	if err != nil {
+		log.Print(err)
+		r.JSON(500, map[string]string{"error": err.Error()})
+		return
+	}
+	r.JSON(200, map[string]interface{}{
+		"candidates": candidates,
+		"disabled":   s.disabledList(),
+	})
+}
+
+func (s *Sango) apiAdminEnable(r render.Render, params martini.Params) {
+	s.setDisabled(r, params["id"], false)
+}
+
+func (s *Sango) apiAdminDisable(r render.Render, params martini.Params) {
+	s.setDisabled(r, params["id"], true)
+}
+
+func (s *Sango) setDisabled(r render.Render, id string, disabled bool) {
+	if _, ok := (<-s.imgch)[id]; !ok {
+		r.JSON(404, map[string]string{"error": "No such environment"})
+		return
+	}
+
+	s.adminMutex.Lock()
+	m := make(map[string]bool)
+	for k := range s.disabled {
+		m[k] = true
+	}
+	if disabled {
+		m[id] = true
+	} else {
+		delete(m, id)
+	}
+	l := make([]string, 0, len(m))
+	for k := range m {
+		l = append(l, k)
+	}
+	data, err := msgpack.Marshal(l)
+	if err == nil {
+		err = s.db.Set("disabled", data, 0)
+	}
+	if err == nil {
+		s.disabled = m
+	}
+	s.adminMutex.Unlock()
+
+	if err != nil {
+		log.Print(err)
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
+		return
+	}
+	r.JSON(200, map[string]interface{}{
+		"id":       id,
+		"disabled": disabled,
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/martini-contrib/render"
+
+	"github.com/h2so5/sango/src"
+)
+
+type healthCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Count int    `json:"count,omitempty"`
+}
+
+func newHealthCheck(err error) healthCheck {
+	if err != nil {
+		return healthCheck{Error: err.Error()}
+	}
+	return healthCheck{OK: true}
+}
+
+// healthCheckTimeout bounds every check so that a wedged dependency is
+// reported as unavailable instead of hanging the probe.
+const healthCheckTimeout = time.Second * 3
+
+// checkWithin returns the error of f, or an error if f takes longer than
+// timeout. f keeps running in the background in that case.
+func checkWithin(timeout time.Duration, f func() error) error {
+	ch := make(chan error, 1)
+	go func() {
+		ch <- f()
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(timeout):
+		return errors.New("timed out")
+	}
+}
+
+func (s *Sango) healthz(r render.Render) {
+	r.JSON(200, map[string]string{"status": "ok"})
+}
+
+// readyz reports whether this node can actually run code.
+func (s *Sango) readyz(r render.Render) {
+	checks := map[string]healthCheck{
+		"docker": newHealthCheck(checkWithin(healthCheckTimeout, sango.Docker.Ping)),
+		"storage": newHealthCheck(checkWithin(healthCheckTimeout, func() error {
+			_, err := s.db.Exists("images")
+			return err
+		})),
+	}
+
+	n := 0
+	for _, img := range s.images() {
+		if _, err := img.NegotiateProtocol(); err == nil {
+			n++
+		}
+	}
+	if n == 0 {
+		checks["images"] = healthCheck{Error: "no image with protocol version " + strconv.Itoa(sango.MinProtocolVersion) + "-" + strconv.Itoa(sango.ProtocolVersion)}
+	} else {
+		checks["images"] = healthCheck{OK: true, Count: n}
+	}
+
+	code := 200
+	status := "ok"
+	for _, c := range checks {
+		if !c.OK {
+			code = 503
+			status = "unavailable"
+		}
+	}
+	if s.sched.Closed() {
+		code = 503
+		status = "shutting down"
+	}
+	r.JSON(code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
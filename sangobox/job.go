@@ -0,0 +1,258 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/h2so5/sango/src"
+)
+
+const jobExpire = time.Hour * 24
+
+// jobPollInterval is how often a running job checks whether it has been
+// cancelled, possibly through another node.
+const jobPollInterval = time.Second
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobFinished  = "finished"
+	JobCancelled = "cancelled"
+)
+
+type Job struct {
+	ID        string        `json:"id"`
+	State     string        `json:"state"`
+	Container string        `json:"-"`
+	Created   time.Time     `json:"created"`
+	Started   *time.Time    `json:"started,omitempty"`
+	Finished  *time.Time    `json:"finished,omitempty"`
+	Response  *ExecResponse `json:"response,omitempty"`
+}
+
+func (s *Sango) getJob(id string) (Job, error) {
+	var job Job
+	data, err := s.db.Get("job/" + id)
+	if err != nil {
+		return job, err
+	}
+	err = msgpack.Unmarshal(data, &job)
+	return job, err
+}
+
+func (s *Sango) putJob(job Job) error {
+	data, err := msgpack.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.db.Set("job/"+job.ID, data, jobExpire)
+}
+
+// cancelJob flags the job as cancelled. The flag is kept apart from the
+// job so that it is never overwritten by the node running the job.
+func (s *Sango) cancelJob(id string) error {
+	return s.db.Set("jobcancel/"+id, []byte{1}, jobExpire)
+}
+
+func (s *Sango) jobCancelled(id string) bool {
+	ok, err := s.db.Exists("jobcancel/" + id)
+	if err != nil {
+		log.Print(err)
+	}
+	return ok
+}
+
+// trackJob registers a job run by this node. The returned channel is
+// closed by abortJob.
+func (s *Sango) trackJob(id string) chan struct{} {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+	ch := make(chan struct{})
+	s.jobs[id] = ch
+	return ch
+}
+
+func (s *Sango) untrackJob(id string) {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+	delete(s.jobs, id)
+}
+
+// abortJob closes the cancel channel of a job run by this node, which drops
+// its ticket from the scheduler queue. It returns false if the job is not
+// run by this node.
+func (s *Sango) abortJob(id string) bool {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+	ch, ok := s.jobs[id]
+	if ok {
+		close(ch)
+		delete(s.jobs, id)
+	}
+	return ok
+}
+
+// watchJob picks up a cancel made through another node until stop is
+// closed. A queued job is dropped from the scheduler queue and the
+// container of a running job is killed.
+func (s *Sango) watchJob(job Job, stop <-chan struct{}) {
+	t := time.NewTicker(jobPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if !s.jobCancelled(job.ID) {
+				continue
+			}
+			s.abortJob(job.ID)
+			// The container may not have been created yet.
+			err := sango.Docker.KillContainer(job.Container)
+			if err == nil {
+				return
+			} else if !sango.IsDockerNotFound(err) {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+func (s *Sango) runJob(job Job, ereq ExecRequest, img sango.Image, t *Ticket) {
+	defer s.untrackJob(job.ID)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.watchJob(job, stop)
+
+	err := s.sched.Wait(t)
+	if err != nil {
+		now := time.Now()
+		job.State = JobCancelled
+		job.Finished = &now
+		if err := s.putJob(job); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+	defer s.sched.Done(t)
+
+	if s.jobCancelled(job.ID) {
+		return
+	}
+
+	now := time.Now()
+	job.State = JobRunning
+	job.Started = &now
+	err = s.putJob(job)
+	if err != nil {
+		log.Print(err)
+	}
+
+	// A cancel that raced with the write above is picked up by watchJob.
+	eres := s.exec("run", ereq, img, job.Container, nil, nil)
+
+	if s.jobCancelled(job.ID) {
+		job.State = JobCancelled
+	} else {
+		job.State = JobFinished
+	}
+	now = time.Now()
+	job.Finished = &now
+	job.Response = &eres
+	err = s.putJob(job)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func (s *Sango) apiJobSubmit(r render.Render, req *http.Request) {
+	ereq, img, code, err := s.decodeRequest(req.Body)
+	if err != nil {
+		r.JSON(code, map[string]string{"error": err.Error()})
+		return
+	}
+	if s.sched.Closed() {
+		r.JSON(503, map[string]string{"error": ErrShuttingDown.Error()})
+		return
+	}
+
+	job := Job{
+		ID:        sango.GenerateID(),
+		State:     JobQueued,
+		Container: sango.GenerateID(),
+		Created:   time.Now(),
+	}
+	err = s.putJob(job)
+	if err != nil {
+		log.Print(err)
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
+		return
+	}
+
+	t := s.ticket(req)
+	t.Cancel = s.trackJob(job.ID)
+	ereq.Pin = ereq.Pin && t.Priority
+	go s.runJob(job, ereq, img, t)
+	r.JSON(202, job)
+}
+
+func (s *Sango) apiJob(r render.Render, params martini.Params) {
+	job, err := s.getJob(params["id"])
+	if err != nil {
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
+		return
+	}
+	r.JSON(200, job)
+}
+
+func (s *Sango) apiJobCancel(r render.Render, params martini.Params) {
+	job, err := s.getJob(params["id"])
+	if err != nil {
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
+		return
+	}
+	if job.State == JobFinished || job.State == JobCancelled {
+		r.JSON(409, map[string]string{"error": "Job already " + job.State})
+		return
+	}
+
+	err = s.cancelJob(job.ID)
+	if err != nil {
+		log.Print(err)
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
+		return
+	}
+
+	// The flag reaches the other nodes through watchJob. A queued job run
+	// by this node leaves the scheduler queue right away.
+	s.abortJob(job.ID)
+
+	// A running job is marked cancelled by the node running it once the
+	// container is gone, so that its response is kept.
+	running := job.State == JobRunning
+	job.State = JobCancelled
+	if running {
+		err := sango.Docker.KillContainer(job.Container)
+		if err != nil && !sango.IsDockerNotFound(err) {
+			log.Print(err)
+		}
+	} else {
+		now := time.Now()
+		job.Finished = &now
+		err = s.putJob(job)
+		if err != nil {
+			log.Print(err)
+		}
+	}
+	r.JSON(200, job)
+}
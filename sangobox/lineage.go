@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"github.com/vmihailenco/msgpack"
+)
+
+const (
+	maxHistoryDepth = 100
+	maxForks        = 100
+)
+
+type LogSummary struct {
+	ID          string    `json:"id"`
+	Parent      string    `json:"parent,omitempty"`
+	Environment string    `json:"environment"`
+	Status      string    `json:"status"`
+	Date        time.Time `json:"date"`
+	Files       []string  `json:"files"`
+}
+
+func (e ExecResponse) Summary() LogSummary {
+	files := make([]string, 0, len(e.Input.Files))
+	for k := range e.Input.Files {
+		files = append(files, k)
+	}
+	sort.Strings(files)
+	return LogSummary{
+		ID:          e.ID,
+		Parent:      e.Parent,
+		Environment: e.Environment.ID,
+		Status:      e.Output.Status,
+		Date:        e.Date,
+		Files:       files,
+	}
+}
+
+func (s *Sango) loadLog(id string) (ExecResponse, error) {
+	var eres ExecResponse
+	data, err := s.db.Get("log/" + id)
+	if err != nil {
+		return eres, err
+	}
+	err = msgpack.Unmarshal(data, &eres)
+	return eres, err
+}
+
+func forksIndex(id string) string {
+	return "forks/" + id
+}
+
+func (s *Sango) apiLogHistory(r render.Render, params martini.Params) {
+	eres, err := s.loadLog(params["id"])
+	if err != nil {
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
+		return
+	}
+
+	history := []LogSummary{}
+	seen := map[string]bool{eres.ID: true}
+	for p := eres.Parent; len(p) > 0 && !seen[p] && len(history) < maxHistoryDepth; {
+		seen[p] = true
+		parent, err := s.loadLog(p)
+		if err != nil {
+			// The ancestor has expired or has been deleted.
+			history = append(history, LogSummary{ID: p})
+			break
+		}
+		history = append(history, parent.Summary())
+		p = parent.Parent
+	}
+	r.JSON(200, history)
+}
+
+func (s *Sango) apiLogForks(r render.Render, params martini.Params) {
+	id := params["id"]
+	n, err := s.db.Exists("log/" + id)
+	if err != nil || !n {
+		if err == nil {
+			r.JSON(404, map[string]string{"error": "Not found"})
+		} else {
+			code, msg := storeError(err)
+			r.JSON(code, map[string]string{"error": msg})
+		}
+		return
+	}
+
+	ids, err := s.db.IndexRange(forksIndex(id), 0, 0, maxForks)
+	if err != nil {
+		log.Print(err)
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
+		return
+	}
+
+	forks := []LogSummary{}
+	for _, f := range ids {
+		eres, err := s.loadLog(f)
+		if err == nil {
+			forks = append(forks, eres.Summary())
+		}
+	}
+	r.JSON(200, forks)
+}
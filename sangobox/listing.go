@@ -0,0 +1,277 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/martini-contrib/render"
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/h2so5/sango/store"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+	maxListScans     = 5
+	maxTrimIndexes   = 10000
+	maxTrimEntries   = 1000
+)
+
+// indexesIndex lists the names of every log index so that the sweeper can
+// trim them.
+const indexesIndex = "indexes"
+
+func logScore(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// listIndexes returns the indexes a public log is listed in.
+func listIndexes(env, status string) []string {
+	return []string{
+		"logs",
+		"logs/env/" + env,
+		"logs/status/" + status,
+		"logs/env/" + env + "/status/" + status,
+	}
+}
+
+// indexLog keeps the secondary indexes and the summary of a saved log.
+// Only public logs are listed, but every log is a fork of its parent.
+func (s *Sango) indexLog(eres ExecResponse, ttl time.Duration) {
+	data, err := msgpack.Marshal(eres.Summary())
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	err = s.db.Set("summary/"+eres.ID, data, ttl)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	score := logScore(eres.Date)
+	if len(eres.Parent) > 0 {
+		s.addToIndex(forksIndex(eres.Parent), eres.ID, score)
+	}
+	if !eres.Public {
+		return
+	}
+	for _, i := range listIndexes(eres.Environment.ID, eres.Output.Status) {
+		s.addToIndex(i, eres.ID, score)
+	}
+}
+
+func (s *Sango) addToIndex(index, id string, score int64) {
+	err := s.db.IndexAdd(index, id, score)
+	if err == nil {
+		err = s.db.IndexAdd(indexesIndex, index, score)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// trimIndexes removes the logs that have expired from the indexes and
+// returns how many were removed. Only the entries older than the retention
+// period are checked, since pinned logs stay listed.
+func (s *Sango) trimIndexes() (int, error) {
+	if s.conf.LogRetention <= 0 {
+		return 0, nil
+	}
+	cutoff := listCursor{score: logScore(time.Now().Add(-s.conf.LogRetention))}
+	indexes, err := s.db.IndexRange(indexesIndex, 0, 0, maxTrimIndexes)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, index := range indexes {
+		_, removed, err := s.scanIndex(index, 0, cutoff, maxTrimEntries, 0, func(LogSummary) bool {
+			return true
+		})
+		n += removed
+		if err != nil {
+			return n, err
+		}
+		if l, err := s.db.IndexRange(index, 0, 0, 1); err == nil && len(l) == 0 {
+			s.db.IndexRemove(indexesIndex, index)
+		}
+	}
+	return n, nil
+}
+
+// scanIndex calls f with the summaries of the logs in index after cursor
+// and from since on, newest first, until f returns false. The index is read
+// batch entries at a time, at most scans times unless scans is zero. The
+// logs that have expired are removed from the index on the way. It returns
+// the cursor of the last log passed to f and how many were removed.
+func (s *Sango) scanIndex(index string, since int64, cursor listCursor, batch, scans int, f func(LogSummary) bool) (listCursor, int, error) {
+	// Every scan starts at the score of the cursor again, so the logs at
+	// that score up to the cursor are fetched and skipped once more.
+	removed := 0
+	skip := 0
+	for n := 0; scans == 0 || n < scans; n++ {
+		want := batch + skip
+		ids, err := s.db.IndexRange(index, since, cursor.bound(), want)
+		if err != nil {
+			return cursor, removed, err
+		}
+		var found []listCursor
+		for _, id := range ids {
+			var sum LogSummary
+			data, err := s.db.Get("summary/" + id)
+			if err == nil {
+				err = msgpack.Unmarshal(data, &sum)
+			}
+			if err == store.ErrNotFound {
+				err = s.db.IndexRemove(index, id)
+				if err != nil {
+					return cursor, removed, err
+				}
+				removed++
+				continue
+			} else if err != nil {
+				return cursor, removed, err
+			}
+			c := listCursor{logScore(sum.Date), id}
+			found = append(found, c)
+			if !cursor.after(c.score, c.id) {
+				continue
+			}
+			cursor = c
+			if !f(sum) {
+				return cursor, removed, nil
+			}
+		}
+		if len(ids) < want {
+			break
+		}
+		if len(found) > 0 && !cursor.after(found[len(found)-1].score, found[len(found)-1].id) {
+			// The whole batch was at or before the cursor, which happens
+			// when many logs share its score. Fetch twice as many.
+			skip = want*2 - batch
+			continue
+		}
+		skip = 0
+		for _, c := range found {
+			if !cursor.after(c.score, c.id) {
+				skip++
+			}
+		}
+	}
+	return cursor, removed, nil
+}
+
+func (s *Sango) unindexLog(eres ExecResponse) {
+	if len(eres.Parent) > 0 {
+		s.db.IndexRemove(forksIndex(eres.Parent), eres.ID)
+	}
+	for _, i := range listIndexes(eres.Environment.ID, eres.Output.Status) {
+		s.db.IndexRemove(i, eres.ID)
+	}
+	s.db.Delete("summary/" + eres.ID)
+}
+
+// parseTime accepts either RFC 3339 or milliseconds since the epoch.
+func parseTime(v string) (int64, error) {
+	if len(v) == 0 {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, err
+	}
+	return logScore(t), nil
+}
+
+// listCursor is the position of the last log of a page. Logs with the
+// same score are ordered by ID, highest first.
+type listCursor struct {
+	score int64
+	id    string
+}
+
+// parseCursor accepts either a time, which excludes the logs at that time
+// and later, or the "score_id" cursor returned as next.
+func parseCursor(v string) (listCursor, error) {
+	if n := strings.LastIndex(v, "_"); n > 0 {
+		score, err := strconv.ParseInt(v[:n], 10, 64)
+		return listCursor{score, v[n+1:]}, err
+	}
+	score, err := parseTime(v)
+	return listCursor{score: score}, err
+}
+
+func (c listCursor) String() string {
+	return strconv.FormatInt(c.score, 10) + "_" + c.id
+}
+
+// bound returns the exclusive upper bound of the scores after c.
+func (c listCursor) bound() int64 {
+	if len(c.id) > 0 {
+		return c.score + 1
+	}
+	return c.score
+}
+
+// after reports whether the log comes after c.
+func (c listCursor) after(score int64, id string) bool {
+	return c.score == 0 || score < c.score || (score == c.score && id < c.id)
+}
+
+func (s *Sango) apiLogList(r render.Render, req *http.Request) {
+	q := req.URL.Query()
+	env := q.Get("environment")
+	status := q.Get("status")
+
+	index := "logs"
+	if len(env) > 0 && len(status) > 0 {
+		index = "logs/env/" + env + "/status/" + status
+	} else if len(env) > 0 {
+		index = "logs/env/" + env
+	} else if len(status) > 0 {
+		index = "logs/status/" + status
+	}
+
+	since, err := parseTime(q.Get("since"))
+	if err != nil {
+		r.JSON(400, map[string]string{"error": "Bad since"})
+		return
+	}
+	cursor, err := parseCursor(q.Get("before"))
+	if err != nil {
+		r.JSON(400, map[string]string{"error": "Bad before"})
+		return
+	}
+	limit := defaultListLimit
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	logs := []LogSummary{}
+	cursor, _, err = s.scanIndex(index, since, cursor, limit, maxListScans, func(sum LogSummary) bool {
+		logs = append(logs, sum)
+		return len(logs) < limit
+	})
+	if err != nil {
+		log.Print(err)
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
+		return
+	}
+
+	res := map[string]interface{}{"logs": logs}
+	if len(logs) == limit {
+		res["next"] = cursor.String()
+	}
+	r.JSON(200, res)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/h2so5/sango/src"
+	"github.com/h2so5/sango/store"
+)
+
+func newListingSango() *Sango {
+	return &Sango{
+		conf: sango.Config{LogRetention: time.Hour},
+		db:   store.NewMemory(),
+	}
+}
+
+// addLog lists a log at date in index. Only live logs have a summary.
+func addLog(t *testing.T, s *Sango, index, id string, date time.Time, live bool) {
+	if live {
+		data, err := msgpack.Marshal(LogSummary{ID: id, Date: date})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.db.Set("summary/"+id, data, 0)
+	}
+	s.addToIndex(index, id, logScore(date))
+}
+
+func TestTrimIndexes(t *testing.T) {
+	s := newListingSango()
+	now := time.Now()
+	old := now.Add(-time.Hour * 2)
+	ms := time.Millisecond
+
+	// Pinned logs fill more than a batch below the cutoff and the expired
+	// logs are all older than them.
+	live := map[string]bool{}
+	for i := 0; i < maxTrimEntries+500; i++ {
+		id := "pinned" + strconv.Itoa(i)
+		addLog(t, s, "logs", id, old.Add(ms*time.Duration(i)), true)
+		live[id] = true
+	}
+	expired := 0
+	for i := 0; i < maxTrimEntries+200; i++ {
+		addLog(t, s, "logs", "expired"+strconv.Itoa(i), old.Add(-ms*time.Duration(10+i)), false)
+		expired++
+	}
+	// Logs with the same score span several batches.
+	tie := old.Add(-time.Minute)
+	for i := 0; i < maxTrimEntries+100; i++ {
+		id := "tie" + strconv.Itoa(i)
+		addLog(t, s, "logs", id, tie, i%2 == 0)
+		if i%2 == 0 {
+			live[id] = true
+		} else {
+			expired++
+		}
+	}
+	// Entries within the retention period are left alone.
+	addLog(t, s, "logs", "recent", now, false)
+	live["recent"] = true
+
+	addLog(t, s, "logs/env/c", "gone", old, false)
+	expired++
+
+	n, err := s.trimIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != expired {
+		t.Errorf("trimmed %d entries; want %d", n, expired)
+	}
+
+	ids, _ := s.db.IndexRange("logs", 0, 0, len(live)+expired)
+	if len(ids) != len(live) {
+		t.Errorf("%d entries left; want %d", len(ids), len(live))
+	}
+	for _, id := range ids {
+		if !live[id] {
+			t.Errorf("expired %s left in the index", id)
+		}
+	}
+	indexes, _ := s.db.IndexRange(indexesIndex, 0, 0, 10)
+	if len(indexes) != 1 || indexes[0] != "logs" {
+		t.Errorf("indexes = %v; want [logs]", indexes)
+	}
+}
+
+func TestScanIndexPages(t *testing.T) {
+	s := newListingSango()
+	date := time.Now()
+	var want []string
+	for i := 0; i < 30; i++ {
+		// Three groups of ten logs with the same score, every third one
+		// expired.
+		d := date.Add(-time.Second * time.Duration(i/10))
+		id := strconv.Itoa(i/10) + string(rune('a'+i%10))
+		live := i%3 != 0
+		addLog(t, s, "logs", id, d, live)
+		if live {
+			want = append(want, id)
+		}
+	}
+
+	for _, limit := range []int{1, 2, 3, 7, 50} {
+		var got []string
+		var cursor listCursor
+		for page := 0; page < 100; page++ {
+			n := 0
+			var err error
+			cursor, _, err = s.scanIndex("logs", 0, cursor, limit, maxListScans, func(sum LogSummary) bool {
+				got = append(got, sum.ID)
+				n++
+				return n < limit
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n < limit {
+				break
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("limit %d: got %v", limit, got)
+		}
+		seen := map[string]bool{}
+		for i, id := range got {
+			if seen[id] {
+				t.Errorf("limit %d: %s listed twice", limit, id)
+			}
+			seen[id] = true
+			if i > 0 && id[0] == got[i-1][0] && id > got[i-1] {
+				t.Errorf("limit %d: %s listed after %s", limit, id, got[i-1])
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/h2so5/sango/store"
+)
+
+var (
+	queueWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sango_queue_wait_seconds",
+		Help:    "Time spent waiting in the run queue.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	})
+	runDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sango_run_duration_seconds",
+		Help:    "Container run duration per image.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"image"})
+	runStatus = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sango_runs_total",
+		Help: "Finished runs by image and output status.",
+	}, []string{"image", "status"})
+	dockerErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sango_docker_errors_total",
+		Help: "Errors returned by the Docker Engine API.",
+	})
+	storageErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sango_storage_errors_total",
+		Help: "Errors returned by the storage backend.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(queueWait, runDuration, runStatus, dockerErrors, storageErrors)
+}
+
+func (s *Sango) registerMetrics() {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "sango_queue_depth",
+		Help: "Runs waiting in the queue.",
+	}, func() float64 {
+		return float64(s.sched.Len())
+	}))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "sango_image_list_age_seconds",
+		Help: "Time since the image list was last refreshed, or -1 if it has not been yet.",
+	}, func() float64 {
+		n := atomic.LoadInt64(&s.imagesUpdated)
+		if n == 0 {
+			return -1
+		}
+		return time.Since(time.Unix(0, n)).Seconds()
+	}))
+	s.Get("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		DisableCompression: true,
+	}).ServeHTTP)
+}
+
+// meteredStore counts the errors of the wrapped store, except for
+// missing keys.
+type meteredStore struct {
+	store.Store
+}
+
+func (m meteredStore) count(err error) error {
+	if err != nil && err != store.ErrNotFound {
+		storageErrors.Inc()
+	}
+	return err
+}
+
+func (m meteredStore) Get(key string) ([]byte, error) {
+	data, err := m.Store.Get(key)
+	return data, m.count(err)
+}
+
+func (m meteredStore) Set(key string, value []byte, ttl time.Duration) error {
+	return m.count(m.Store.Set(key, value, ttl))
+}
+
+func (m meteredStore) Exists(key string) (bool, error) {
+	n, err := m.Store.Exists(key)
+	return n, m.count(err)
+}
+
+func (m meteredStore) Delete(key string) error {
+	return m.count(m.Store.Delete(key))
+}
+
+func (m meteredStore) IndexAdd(index, member string, score int64) error {
+	return m.count(m.Store.IndexAdd(index, member, score))
+}
+
+func (m meteredStore) IndexRemove(index, member string) error {
+	return m.count(m.Store.IndexRemove(index, member))
+}
+
+func (m meteredStore) IndexRange(index string, since, before int64, limit int) ([]string, error) {
+	l, err := m.Store.IndexRange(index, since, before, limit)
+	return l, m.count(err)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/h2so5/sango/src"
+	"github.com/h2so5/sango/store"
+)
+
+func generateDeleteToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashDeleteToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// saveLog stores eres as a new log. The delete token is returned to the
+// caller only; the log keeps its hash.
+func (s *Sango) saveLog(eres *ExecResponse, pin bool) error {
+	token, err := generateDeleteToken()
+	if err != nil {
+		return err
+	}
+	eres.ID = sango.GenerateID()
+	eres.Owner = hashDeleteToken(token)
+
+	var ttl time.Duration
+	if !pin && s.conf.LogRetention > 0 {
+		ttl = s.conf.LogRetention
+		expires := eres.Date.Add(ttl)
+		eres.Expires = &expires
+	}
+
+	data, err := msgpack.Marshal(eres)
+	if err != nil {
+		return err
+	}
+	err = s.db.Set("log/"+eres.ID, data, ttl)
+	if err != nil {
+		return err
+	}
+	eres.DeleteToken = token
+
+	s.indexLog(*eres, ttl)
+	return nil
+}
+
+func (s *Sango) apiLogDelete(r render.Render, params martini.Params, req *http.Request) {
+	token := req.Header.Get("X-Delete-Token")
+	if len(token) == 0 {
+		token = req.FormValue("delete_token")
+	}
+	if len(token) == 0 {
+		r.JSON(401, map[string]string{"error": "Delete token required"})
+		return
+	}
+
+	key := "log/" + params["id"]
+	data, err := s.db.Get(key)
+	if err != nil {
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
+		return
+	}
+	var eres ExecResponse
+	err = msgpack.Unmarshal(data, &eres)
+	if err != nil {
+		log.Print(err)
+		r.JSON(500, map[string]string{"error": "Internal error"})
+		return
+	}
+
+	h := hashDeleteToken(token)
+	if len(eres.Owner) == 0 || subtle.ConstantTimeCompare([]byte(h), []byte(eres.Owner)) != 1 {
+		r.JSON(403, map[string]string{"error": "Invalid delete token"})
+		return
+	}
+
+	err = s.db.Delete(key)
+	if err != nil {
+		log.Print(err)
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
+		return
+	}
+	s.unindexLog(eres)
+	r.JSON(200, map[string]string{"id": eres.ID})
+}
+
+// sweep periodically removes expired entries from backends that have no
+// native TTL, and expired logs from the indexes. sw is nil if the backend
+// has native TTL.
+func (s *Sango) sweep(sw store.Sweeper) {
+	tick := time.Tick(s.conf.SweepInterval)
+	for {
+		<-tick
+		if sw != nil {
+			n, err := sw.Sweep()
+			if err != nil {
+				log.Print(err)
+			} else if n > 0 {
+				log.Printf("swept %d expired entries", n)
+			}
+		}
+		n, err := s.trimIndexes()
+		if err != nil {
+			log.Print(err)
+		} else if n > 0 {
+			log.Printf("trimmed %d expired index entries", n)
+		}
+	}
+}
@@ -9,15 +9,19 @@ import (
 	"io"
 	"log"
 	"math/rand"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"bitbucket.org/kardianos/osext"
-	"github.com/garyburd/redigo/redis"
 	"github.com/go-martini/martini"
 	"github.com/gorilla/websocket"
 	"github.com/martini-contrib/gzip"
@@ -25,18 +29,33 @@ import (
 	"github.com/vmihailenco/msgpack"
 
 	"github.com/h2so5/sango/src"
+	"github.com/h2so5/sango/store"
 )
 
 var sangoPath string
 var configFile *string = flag.String("f", "/etc/sango.yml", "Specify config file")
-var cmdCacheSeconds = 60
+var cmdCacheTTL = time.Second * 60
 
 type Sango struct {
 	*martini.ClassicMartini
 	conf  sango.Config
-	db    redis.Conn
+	db    store.Store
 	imgch chan sango.ImageList
-	reqch chan int
+	sched *Scheduler
+
+	scanMutex  sync.Mutex
+	imgupdate  chan sango.ImageList
+	adminMutex sync.Mutex
+	candidates []sango.ImageCandidate
+	disabled   map[string]bool
+
+	containersMutex sync.Mutex
+	containers      map[string]bool
+
+	jobsMutex sync.Mutex
+	jobs      map[string]chan struct{}
+
+	imagesUpdated int64
 }
 
 func NewSango(conf sango.Config) *Sango {
@@ -48,15 +67,7 @@ func NewSango(conf sango.Config) *Sango {
 		Extensions: []string{".html"},
 	}))
 
-	eaddr := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
-	eport := os.Getenv("REDIS_PORT_6379_TCP_PORT")
-
-	addr := ":6379"
-	if len(eaddr) > 0 && len(eport) > 0 {
-		addr = eaddr + ":" + eport
-	}
-
-	db, err := redis.Dial("tcp", addr)
+	db, err := openStore(conf)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -64,19 +75,23 @@ func NewSango(conf sango.Config) *Sango {
 	s := &Sango{
 		ClassicMartini: m,
 		conf:           conf,
-		db:             db,
+		db:             meteredStore{db},
 		imgch:          make(chan sango.ImageList),
-		reqch:          make(chan int, conf.ExecLimit),
+		sched:          NewScheduler(conf.ExecLimit),
+		containers:     make(map[string]bool),
+		jobs:           make(map[string]chan struct{}),
+		imgupdate:      make(chan sango.ImageList),
+	}
+
+	sango.Docker.ErrorHook = func(err error) {
+		dockerErrors.Inc()
 	}
 
-	imgdch := make(chan sango.ImageList)
 	go func() {
 		tick := time.Tick(1 * time.Hour)
 		for {
-			images, err := sango.MakeImageList(s.conf.ImageDir, false)
-			if err == nil {
-				imgdch <- images
-			} else {
+			_, err := s.rescan(false)
+			if err != nil {
 				log.Print(err)
 			}
 			<-tick
@@ -85,19 +100,23 @@ func NewSango(conf sango.Config) *Sango {
 
 	go func() {
 		var images sango.ImageList
-		data, err := redis.Bytes(s.db.Do("GET", "images"))
+		data, err := s.db.Get("images")
 		if err == nil {
 			err = msgpack.Unmarshal(data, &images)
 		}
+		if err == nil {
+			err = images.CompileOptions()
+		}
 		for {
 			select {
-			case i := <-imgdch:
+			case i := <-s.imgupdate:
 				images = i
+				atomic.StoreInt64(&s.imagesUpdated, time.Now().UnixNano())
 				data, err := msgpack.Marshal(images)
 				if err != nil {
 					log.Print(err)
 				} else {
-					_, err := s.db.Do("SET", "images", data)
+					err := s.db.Set("images", data, 0)
 					if err != nil {
 						log.Print(err)
 					}
@@ -107,6 +126,9 @@ func NewSango(conf sango.Config) *Sango {
 		}
 	}()
 
+	sw, _ := db.(store.Sweeper)
+	go s.sweep(sw)
+
 	ch := time.Tick(conf.CleanInterval)
 	go func() {
 		for {
@@ -116,15 +138,34 @@ func NewSango(conf sango.Config) *Sango {
 		}
 	}()
 
+	m.Group("/api/admin", func(r martini.Router) {
+		r.Get("/images", s.apiAdminImages)
+		r.Post("/images/rescan", s.apiAdminRescan)
+		r.Post("/images/:id/enable", s.apiAdminEnable)
+		r.Post("/images/:id/disable", s.apiAdminDisable)
+	}, s.requireAdmin)
+
 	m.Group("/api", func(r martini.Router) {
 		r.Get("/list", s.apiImageList)
 		r.Post("/run", s.apiRun)
 		r.Post("/cmd", s.apiCmd)
 		r.Get("/run/stream", s.apiRunStreaming)
+		r.Get("/log", s.apiLogList)
 		r.Get("/log/:id", s.apiLog)
+		r.Delete("/log/:id", s.apiLogDelete)
+		r.Get("/log/:id/history", s.apiLogHistory)
+		r.Get("/log/:id/forks", s.apiLogForks)
+		r.Get("/log/:id/artifacts/:name", s.apiArtifact)
+		r.Post("/jobs", s.apiJobSubmit)
+		r.Get("/jobs/:id", s.apiJob)
+		r.Delete("/jobs/:id", s.apiJobCancel)
 		r.Post("/:act", s.apiAct)
 	})
 
+	s.registerMetrics()
+
+	m.Get("/healthz", s.healthz)
+	m.Get("/readyz", s.readyz)
 	m.Get("/", s.index)
 	m.Get("/:id", s.log)
 	m.Get("/template/:env", s.template)
@@ -133,19 +174,56 @@ func NewSango(conf sango.Config) *Sango {
 	return s
 }
 
+func openStore(conf sango.Config) (store.Store, error) {
+	switch conf.Storage {
+	case "leveldb":
+		return store.NewLevelDB(conf.Database)
+	case "memory":
+		return store.NewMemory(), nil
+	}
+
+	addr := conf.RedisAddr
+	if len(addr) == 0 {
+		eaddr := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
+		eport := os.Getenv("REDIS_PORT_6379_TCP_PORT")
+
+		addr = ":6379"
+		if len(eaddr) > 0 && len(eport) > 0 {
+			addr = eaddr + ":" + eport
+		}
+	}
+	return store.NewRedis(addr)
+}
+
 func (s *Sango) getImageList() sango.ImageList {
-	data, err := redis.Bytes(s.db.Do("GET", "images"))
+	data, err := s.db.Get("images")
 
 	var list sango.ImageList
 	if err == nil {
 		err = msgpack.Unmarshal(data, &list)
+		if err == nil {
+			err = list.CompileOptions()
+		}
 		log.Print(err)
 	}
 	return list
 }
 
+// images returns the enabled images.
 func (s *Sango) images() sango.ImageList {
-	return <-s.imgch
+	images := <-s.imgch
+	s.adminMutex.Lock()
+	defer s.adminMutex.Unlock()
+	if len(s.disabled) == 0 {
+		return images
+	}
+	l := make(sango.ImageList)
+	for k, v := range images {
+		if !s.disabled[k] {
+			l[k] = v
+		}
+	}
+	return l
 }
 
 func (s *Sango) index(r render.Render) {
@@ -155,10 +233,15 @@ func (s *Sango) index(r render.Render) {
 	})
 }
 
-func (s *Sango) log(r render.Render, params martini.Params) {
+func (s *Sango) log(r render.Render, res http.ResponseWriter, params martini.Params) {
 	id := params["id"]
 
-	n, err := redis.Bool(s.db.Do("EXISTS", "log/"+id))
+	n, err := s.db.Exists("log/" + id)
+	if store.IsUnavailable(err) {
+		log.Print(err)
+		http.Error(res, "Storage unavailable", 503)
+		return
+	}
 	if err != nil || !n {
 		r.Redirect("/")
 		return
@@ -184,7 +267,7 @@ func (s *Sango) apiImageList(r render.Render) {
 	r.JSON(200, s.imageArray())
 }
 
-func (s *Sango) run(act string, req io.Reader, msgch chan<- *sango.Message) (ExecResponse, int, error) {
+func (s *Sango) decodeRequest(req io.Reader) (ExecRequest, sango.Image, int, error) {
 	reader := io.LimitReader(req, s.conf.UploadLimit)
 	d := json.NewDecoder(reader)
 	var ereq ExecRequest
@@ -192,48 +275,115 @@ func (s *Sango) run(act string, req io.Reader, msgch chan<- *sango.Message) (Exe
 	if err != nil {
 		log.Print(err)
 		if reader.(*io.LimitedReader).N <= 0 {
-			return ExecResponse{}, 413, errors.New("Too large input")
+			return ereq, sango.Image{}, 413, errors.New("Too large input")
 		} else {
-			return ExecResponse{}, 400, errors.New("Bad request")
+			return ereq, sango.Image{}, 400, errors.New("Bad request")
 		}
 	}
-	if len(ereq.Input.Files) == 0 {
-		return ExecResponse{}, 400, errors.New("No input files")
+	if len(ereq.Input.Files)+len(ereq.Input.BinaryFiles) == 0 {
+		return ereq, sango.Image{}, 400, errors.New("No input files")
+	}
+	if len(ereq.Input.Tests) > s.conf.TestLimit {
+		return ereq, sango.Image{}, 400, fmt.Errorf("Too many tests (max %d)", s.conf.TestLimit)
+	}
+	stdin := len(ereq.Input.Stdin)
+	for _, t := range ereq.Input.Tests {
+		stdin += len(t.Stdin)
+	}
+	if stdin > s.conf.StdinLimit {
+		return ereq, sango.Image{}, 400, fmt.Errorf("Too large stdin (max %d bytes)", s.conf.StdinLimit)
 	}
 	img, ok := s.images()[ereq.Environment]
 	if !ok {
-		return ExecResponse{}, 501, errors.New("No such environment")
+		return ereq, sango.Image{}, 501, errors.New("No such environment")
+	}
+	if len(ereq.Input.Main) > 0 {
+		if _, ok := ereq.Input.Files[ereq.Input.Main]; !ok {
+			return ereq, sango.Image{}, 400, errors.New("No such main file")
+		}
+		if !img.IsSource(ereq.Input.Main) {
+			return ereq, sango.Image{}, 400, fmt.Errorf("Main file must have one of the extensions %v", img.Extensions)
+		}
+	}
+	if _, err := img.NormalizeOptions(ereq.Input.Options); err != nil {
+		return ereq, sango.Image{}, 400, err
+	}
+	in := ereq.Input
+	in.Limits = s.limits(ereq, img)
+	if err := img.CheckInput(in); err != nil {
+		return ereq, sango.Image{}, 400, err
 	}
-	s.reqch <- 0
-	defer func() { <-s.reqch }()
+	if len(ereq.Parent) > 0 {
+		if n, err := s.db.Exists("log/" + ereq.Parent); err == nil && !n {
+			ereq.Parent = ""
+		}
+	}
+	return ereq, img, 200, nil
+}
+
+func (s *Sango) run(act string, req io.Reader, t *Ticket, msgch chan<- *sango.Message) (ExecResponse, int, error) {
+	ereq, img, code, err := s.decodeRequest(req)
+	if err != nil {
+		return ExecResponse{}, code, err
+	}
+	ereq.Pin = ereq.Pin && t.Priority
+	err = s.sched.Wait(t)
+	if err != nil {
+		return ExecResponse{}, 503, err
+	}
+	defer s.sched.Done(t)
+	return s.exec(act, ereq, img, sango.GenerateID(), nil, msgch), 200, nil
+}
+
+// limits returns the limits of a run of ereq on img.
+func (s *Sango) limits(ereq ExecRequest, img sango.Image) sango.Limits {
+	limits := s.conf.Limits.Merge(img.Limits)
+	if ereq.Limits != nil {
+		limits = limits.Narrow(*ereq.Limits)
+	}
+	return limits
+}
 
-	out, err := img.Exec(act, ereq.Input, msgch)
+func (s *Sango) exec(act string, ereq ExecRequest, img sango.Image, name string, stdin io.Reader, msgch chan<- *sango.Message) ExecResponse {
+	limits := s.limits(ereq, img)
+
+	s.trackContainer(name)
+	start := time.Now()
+	out, err := img.Exec(name, act, ereq.Input, limits, stdin, msgch)
+	s.untrackContainer(name)
 	if err != nil {
 		log.Print(err)
 	}
+	runDuration.WithLabelValues(img.ID).Observe(time.Since(start).Seconds())
+	runStatus.WithLabelValues(img.ID, out.Status).Inc()
 	eres := ExecResponse{
+		Parent:      ereq.Parent,
+		Public:      ereq.Public,
 		Environment: img,
 		Input:       ereq.Input,
 		Output:      out,
 		Date:        time.Now(),
 	}
 	if act != "run" || !ereq.Volatile {
-		eres.ID = sango.GenerateID()
-		data, err := msgpack.Marshal(eres)
+		err := s.saveLog(&eres, ereq.Pin)
 		if err != nil {
 			log.Print(err)
-		} else {
-			_, err := s.db.Do("SET", "log/"+eres.ID, data)
-			if err != nil {
-				log.Print(err)
-			}
+			eres.ID = ""
 		}
 	}
-	return eres, 200, nil
+	return eres
+}
+
+// requestTicket returns the ticket of a request that is abandoned when the
+// client disconnects.
+func (s *Sango) requestTicket(req *http.Request) *Ticket {
+	t := s.ticket(req)
+	t.Cancel = req.Context().Done()
+	return t
 }
 
 func (s *Sango) apiRun(r render.Render, res http.ResponseWriter, req *http.Request) {
-	eres, code, err := s.run("run", req.Body, nil)
+	eres, code, err := s.run("run", req.Body, s.requestTicket(req), nil)
 	if err != nil {
 		r.JSON(code, map[string]string{"error": err.Error()})
 	} else {
@@ -249,7 +399,7 @@ func (s *Sango) getCmd(req ExecRequest) (map[string]string, int, error) {
 	}
 
 	id := md5.Sum(data)
-	data, err = redis.Bytes(s.db.Do("GET", "cache/cmd/"+string(id[:])))
+	data, err = s.db.Get("cache/cmd/" + string(id[:]))
 	if err == nil {
 		err := msgpack.Unmarshal(data, &c)
 		if err != nil {
@@ -263,6 +413,9 @@ func (s *Sango) getCmd(req ExecRequest) (map[string]string, int, error) {
 	if !ok {
 		return c, 501, errors.New("No such environment")
 	}
+	if _, err := img.NormalizeOptions(req.Input.Options); err != nil {
+		return c, 400, err
+	}
 
 	cmd, err := img.GetCommand(req.Input)
 	if err != nil {
@@ -275,7 +428,7 @@ func (s *Sango) getCmd(req ExecRequest) (map[string]string, int, error) {
 	if err != nil {
 		log.Print(err)
 	} else {
-		_, err := s.db.Do("SETEX", "cache/cmd/"+string(id[:]), cmdCacheSeconds, data)
+		err := s.db.Set("cache/cmd/"+string(id[:]), data, cmdCacheTTL)
 		if err != nil {
 			log.Print(err)
 		}
@@ -285,7 +438,7 @@ func (s *Sango) getCmd(req ExecRequest) (map[string]string, int, error) {
 }
 
 func (s *Sango) apiAct(r render.Render, params martini.Params, res http.ResponseWriter, req *http.Request) {
-	eres, code, err := s.run(params["act"], req.Body, nil)
+	eres, code, err := s.run(params["act"], req.Body, s.requestTicket(req), nil)
 	if err != nil {
 		r.JSON(code, map[string]string{"error": err.Error()})
 	} else {
@@ -338,24 +491,85 @@ func (s *Sango) apiRunStreaming(res http.ResponseWriter, req *http.Request) {
 		}
 	}()
 
-	eres, _, err := s.run("run", r, msgch)
+	ereq, img, _, err := s.decodeRequest(r)
 	if err != nil {
 		log.Print(err)
+		ws.WriteJSON(map[string]string{"error": err.Error()})
 		return
 	}
 
-	if err != nil {
-		ws.WriteJSON(map[string]string{"error": err.Error()})
-	} else {
+	var stdin io.Reader
+	var pw *io.PipeWriter
+	if ereq.Input.Interactive {
+		pr, w := io.Pipe()
+		defer pr.Close()
+		stdin, pw = pr, w
+	}
+	gone := make(chan struct{})
+	go readStdinFrames(ws, pw, gone)
+
+	// Queue updates are only sent before the run starts, so they never race
+	// with the messages written by the goroutine above.
+	t := s.ticket(req)
+	t.Cancel = gone
+	ereq.Pin = ereq.Pin && t.Priority
+	t.Notify = func(st QueueStatus) {
+		ws.WriteJSON(map[string]interface{}{"tag": "queue", "data": st})
+	}
+
+	err = s.sched.Wait(t)
+	if err == nil {
+		eres := s.exec("run", ereq, img, sango.GenerateID(), stdin, msgch)
+		s.sched.Done(t)
 		ws.WriteJSON(map[string]interface{}{"tag": "result", "data": eres})
+	} else {
+		ws.WriteJSON(map[string]string{"error": err.Error()})
 	}
 }
 
+// readStdinFrames forwards the stdin frames sent by the client to w as
+// msgpack messages for the agent, unless w is nil. gone is closed once the
+// client has disconnected.
+func readStdinFrames(ws *websocket.Conn, w *io.PipeWriter, gone chan<- struct{}) {
+	defer close(gone)
+	var e *msgpack.Encoder
+	if w != nil {
+		e = msgpack.NewEncoder(w)
+	}
+	for {
+		var msg sango.Message
+		err := ws.ReadJSON(&msg)
+		if err != nil {
+			if w != nil {
+				w.CloseWithError(err)
+			}
+			return
+		}
+		if w != nil && (msg.Tag == "stdin" || msg.Tag == "eof") {
+			err := e.Encode(msg)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// storeError maps an error returned by the store to a response.
+func storeError(err error) (int, string) {
+	if err == store.ErrNotFound {
+		return 404, "Not found"
+	} else if store.IsUnavailable(err) {
+		return 503, "Storage unavailable"
+	}
+	return 500, "Internal error"
+}
+
 func (s *Sango) apiLog(r render.Render, params martini.Params) {
-	data, err := redis.Bytes(s.db.Do("GET", "log/"+params["id"]))
+	data, err := s.db.Get("log/" + params["id"])
 	if err != nil {
 		log.Print(err)
-		r.JSON(404, map[string]string{"error": "Not found"})
+		code, msg := storeError(err)
+		r.JSON(code, map[string]string{"error": msg})
 		return
 	}
 	var res ExecResponse
@@ -368,6 +582,40 @@ func (s *Sango) apiLog(r render.Render, params martini.Params) {
 	r.JSON(200, res)
 }
 
+func (s *Sango) apiArtifact(res http.ResponseWriter, params martini.Params) {
+	data, err := s.db.Get("log/" + params["id"])
+	if err != nil {
+		code, msg := storeError(err)
+		http.Error(res, msg, code)
+		return
+	}
+	var eres ExecResponse
+	err = msgpack.Unmarshal(data, &eres)
+	if err != nil {
+		log.Print(err)
+		http.Error(res, "Internal error", 500)
+		return
+	}
+	for _, a := range eres.Output.Artifacts {
+		if a.Name != params["name"] || a.Truncated {
+			continue
+		}
+		// The name is chosen by the program, so the content is never
+		// rendered by the browser.
+		d := mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(a.Name)})
+		if len(d) == 0 {
+			d = "attachment"
+		}
+		res.Header().Set("Content-Type", "application/octet-stream")
+		res.Header().Set("X-Content-Type-Options", "nosniff")
+		res.Header().Set("Content-Disposition", d)
+		res.WriteHeader(200)
+		res.Write(a.Data)
+		return
+	}
+	http.Error(res, "Not found", 404)
+}
+
 func (s *Sango) template(res http.ResponseWriter, params martini.Params) {
 	env := params["env"]
 	img, ok := s.images()[env]
@@ -397,17 +645,27 @@ func (s *Sango) Close() {
 }
 
 type ExecRequest struct {
-	Environment string      `json:"environment"`
-	Volatile    bool        `json:"volatile"`
-	Input       sango.Input `json:"input"`
+	Environment string        `json:"environment"`
+	Volatile    bool          `json:"volatile"`
+	Input       sango.Input   `json:"input"`
+	Limits      *sango.Limits `json:"limits,omitempty"`
+	Pin         bool          `json:"pin,omitempty"`
+	Parent      string        `json:"parent,omitempty"`
+	// Public lists the log in GET /api/log. Logs are unlisted by default.
+	Public bool `json:"public,omitempty"`
 }
 
 type ExecResponse struct {
 	ID          string       `json:"id,omitempty"`
+	Parent      string       `json:"parent,omitempty"`
+	Public      bool         `json:"public,omitempty"`
 	Environment sango.Image  `json:"environment"`
 	Input       sango.Input  `json:"input"`
 	Output      sango.Output `json:"output"`
 	Date        time.Time    `json:"date"`
+	Expires     *time.Time   `json:"expires,omitempty"`
+	DeleteToken string       `json:"delete-token,omitempty"`
+	Owner       string       `json:"-"`
 }
 
 func main() {
@@ -429,7 +687,22 @@ func main() {
 
 	conf := sango.LoadConfig(*configFile)
 	s := NewSango(conf)
-	defer s.Close()
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", conf.Port), Handler: s}
+
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, syscall.SIGTERM, syscall.SIGINT)
+	done := make(chan struct{})
+	go func() {
+		sig := <-sigch
+		log.Printf("received %v, shutting down...", sig)
+		s.Shutdown(srv, conf.ShutdownGrace)
+		close(done)
+	}()
+
 	log.Printf("listening on :%d\n", conf.Port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", conf.Port), s))
+	err = srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
 }
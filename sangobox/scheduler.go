@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const initialRunEstimate = time.Second * 2
+
+var ErrShuttingDown = errors.New("Shutting down")
+var ErrClientGone = errors.New("Client gone")
+
+type QueueStatus struct {
+	Position int     `json:"position"`
+	ETA      float64 `json:"eta"`
+}
+
+type Ticket struct {
+	Client   string
+	Priority bool
+	Notify   func(QueueStatus)
+	// Cancel is closed when the client goes away. The ticket is then
+	// dropped from the queue.
+	Cancel <-chan struct{}
+
+	ready   chan struct{}
+	update  chan QueueStatus
+	started time.Time
+	err     error
+}
+
+// queue holds the waiting tickets of one priority level. Clients are served
+// round-robin so that one client's batch can't starve the others.
+type queue struct {
+	clients []string
+	waiting map[string][]*Ticket
+}
+
+func (q *queue) push(t *Ticket) {
+	if len(q.waiting[t.Client]) == 0 {
+		q.clients = append(q.clients, t.Client)
+	}
+	q.waiting[t.Client] = append(q.waiting[t.Client], t)
+}
+
+func (q *queue) pop() *Ticket {
+	if len(q.clients) == 0 {
+		return nil
+	}
+	c := q.clients[0]
+	q.clients = q.clients[1:]
+	l := q.waiting[c]
+	t := l[0]
+	if len(l) > 1 {
+		q.waiting[c] = l[1:]
+		q.clients = append(q.clients, c)
+	} else {
+		delete(q.waiting, c)
+	}
+	return t
+}
+
+// remove drops t from the queue. It returns false if t is not waiting.
+func (q *queue) remove(t *Ticket) bool {
+	l := q.waiting[t.Client]
+	for i, w := range l {
+		if w != t {
+			continue
+		}
+		if len(l) > 1 {
+			q.waiting[t.Client] = append(l[:i:i], l[i+1:]...)
+			return true
+		}
+		delete(q.waiting, t.Client)
+		for j, c := range q.clients {
+			if c == t.Client {
+				q.clients = append(q.clients[:j:j], q.clients[j+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// order returns the waiting tickets in the order they will be served.
+func (q *queue) order() []*Ticket {
+	var l []*Ticket
+	for k := 0; ; k++ {
+		n := len(l)
+		for _, c := range q.clients {
+			if w := q.waiting[c]; k < len(w) {
+				l = append(l, w[k])
+			}
+		}
+		if len(l) == n {
+			return l
+		}
+	}
+}
+
+type Scheduler struct {
+	mutex   sync.Mutex
+	limit   int
+	running int
+	average time.Duration
+	queues  [2]queue
+	closed  bool
+	idle    chan struct{}
+}
+
+func NewScheduler(limit int) *Scheduler {
+	s := &Scheduler{
+		limit:   limit,
+		average: initialRunEstimate,
+		idle:    make(chan struct{}),
+	}
+	for i := range s.queues {
+		s.queues[i].waiting = make(map[string][]*Ticket)
+	}
+	return s
+}
+
+// Wait blocks until t may run. While waiting, t.Notify is called with the
+// current queue position every time it changes. If the scheduler is closed
+// before t runs, ErrShuttingDown is returned, and if t.Cancel is closed,
+// ErrClientGone is returned. Done must not be called after an error.
+func (s *Scheduler) Wait(t *Ticket) error {
+	t.ready = make(chan struct{})
+	t.update = make(chan QueueStatus, 1)
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrShuttingDown
+	}
+	if t.Priority {
+		s.queues[0].push(t)
+	} else {
+		s.queues[1].push(t)
+	}
+	s.dispatch()
+	s.mutex.Unlock()
+
+	start := time.Now()
+	for {
+		select {
+		case <-t.ready:
+			if t.err == nil {
+				queueWait.Observe(time.Since(start).Seconds())
+			}
+			return t.err
+		case st := <-t.update:
+			if t.Notify != nil {
+				t.Notify(st)
+			}
+		case <-t.Cancel:
+			s.mutex.Lock()
+			queued := s.queues[0].remove(t) || s.queues[1].remove(t)
+			if queued {
+				s.dispatch()
+			}
+			s.mutex.Unlock()
+			if queued {
+				return ErrClientGone
+			}
+			// t has been dispatched or rejected in the meantime.
+			<-t.ready
+			if t.err != nil {
+				return t.err
+			}
+			s.Done(t)
+			return ErrClientGone
+		}
+	}
+}
+
+// Done releases the slot held by t.
+func (s *Scheduler) Done(t *Ticket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.running--
+	d := time.Now().Sub(t.started)
+	s.average = (s.average*4 + d) / 5
+	if s.closed && s.running == 0 {
+		close(s.idle)
+	}
+	s.dispatch()
+}
+
+// Close rejects the waiting and all future tickets. Tickets that are
+// already running are not affected.
+func (s *Scheduler) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for i := range s.queues {
+		for _, t := range s.queues[i].order() {
+			t.err = ErrShuttingDown
+			close(t.ready)
+		}
+		s.queues[i] = queue{waiting: make(map[string][]*Ticket)}
+	}
+	if s.running == 0 {
+		close(s.idle)
+	}
+}
+
+// Drain waits until the running tickets are done after Close. It returns
+// false if they are still running after timeout.
+func (s *Scheduler) Drain(timeout time.Duration) bool {
+	select {
+	case <-s.idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (s *Scheduler) Closed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+func (s *Scheduler) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.queues[0].order()) + len(s.queues[1].order())
+}
+
+func (s *Scheduler) dispatch() {
+	for s.running < s.limit {
+		t := s.queues[0].pop()
+		if t == nil {
+			t = s.queues[1].pop()
+		}
+		if t == nil {
+			break
+		}
+		s.running++
+		t.started = time.Now()
+		close(t.ready)
+	}
+
+	l := append(s.queues[0].order(), s.queues[1].order()...)
+	for i, t := range l {
+		st := QueueStatus{
+			Position: i + 1,
+			ETA:      (float64(i/s.limit) + 1) * s.average.Seconds(),
+		}
+		select {
+		case <-t.update:
+		default:
+		}
+		t.update <- st
+	}
+}
+
+func requestToken(req *http.Request) string {
+	if a := req.Header.Get("Authorization"); strings.HasPrefix(a, "Bearer ") {
+		return strings.TrimPrefix(a, "Bearer ")
+	}
+	return req.URL.Query().Get("token")
+}
+
+func hasToken(req *http.Request, tokens []string) bool {
+	token := requestToken(req)
+	if len(token) == 0 {
+		return false
+	}
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Sango) authenticated(req *http.Request) bool {
+	return hasToken(req, s.conf.APITokens)
+}
+
+// trustedProxy reports whether addr is one of the trusted proxies, which
+// are given as addresses or CIDR ranges.
+func (s *Sango) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, p := range s.conf.TrustedProxies {
+		if _, n, err := net.ParseCIDR(p); err == nil {
+			if n.Contains(ip) {
+				return true
+			}
+		} else if q := net.ParseIP(p); q != nil && q.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client of req. Behind trusted
+// proxies, it is the last address in X-Forwarded-For that isn't a trusted
+// proxy itself.
+func (s *Sango) clientAddr(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if !s.trustedProxy(host) {
+		return host
+	}
+	l := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(l) - 1; i >= 0; i-- {
+		a := strings.TrimSpace(l[i])
+		if len(a) == 0 {
+			break
+		}
+		host = a
+		if !s.trustedProxy(a) {
+			break
+		}
+	}
+	return host
+}
+
+func (s *Sango) ticket(req *http.Request) *Ticket {
+	if s.authenticated(req) {
+		return &Ticket{Client: "token/" + requestToken(req), Priority: true}
+	}
+	return &Ticket{Client: "addr/" + s.clientAddr(req)}
+}
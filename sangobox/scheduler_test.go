@@ -0,0 +1,256 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/h2so5/sango/src"
+)
+
+func ticketNames(l []*Ticket, names map[*Ticket]string) []string {
+	var r []string
+	for _, t := range l {
+		r = append(r, names[t])
+	}
+	return r
+}
+
+var queueTests = []struct {
+	push   []string
+	remove []string
+	order  string
+}{
+	{nil, nil, ""},
+	{[]string{"a1", "a2", "a3"}, nil, "a1,a2,a3"},
+	{[]string{"a1", "a2", "a3", "b1", "c1"}, nil, "a1,b1,c1,a2,a3"},
+	{[]string{"a1", "b1", "a2", "b2"}, nil, "a1,b1,a2,b2"},
+	{[]string{"a1", "a2", "a3", "b1"}, []string{"a2"}, "a1,b1,a3"},
+	{[]string{"a1", "b1", "c1"}, []string{"b1"}, "a1,c1"},
+	{[]string{"a1", "a2", "b1", "c1"}, []string{"a1", "a2"}, "b1,c1"},
+	{[]string{"a1", "b1"}, []string{"a1", "b1"}, ""},
+}
+
+func TestQueue(t *testing.T) {
+	for i, tt := range queueTests {
+		q := queue{waiting: make(map[string][]*Ticket)}
+		tickets := make(map[string]*Ticket)
+		names := make(map[*Ticket]string)
+		for _, n := range tt.push {
+			tk := &Ticket{Client: n[:1]}
+			tickets[n] = tk
+			names[tk] = n
+			q.push(tk)
+		}
+		for _, n := range tt.remove {
+			if !q.remove(tickets[n]) {
+				t.Errorf("#%d: remove(%s) = false", i, n)
+			}
+			if q.remove(tickets[n]) {
+				t.Errorf("#%d: second remove(%s) = true", i, n)
+			}
+		}
+
+		order := strings.Join(ticketNames(q.order(), names), ",")
+		if order != tt.order {
+			t.Errorf("#%d: order = %s; want %s", i, order, tt.order)
+		}
+		var popped []string
+		for tk := q.pop(); tk != nil; tk = q.pop() {
+			popped = append(popped, names[tk])
+		}
+		if p := strings.Join(popped, ","); p != tt.order {
+			t.Errorf("#%d: pop = %s; want %s", i, p, tt.order)
+		}
+		if len(q.clients) != 0 || len(q.waiting) != 0 {
+			t.Errorf("#%d: queue not empty: %v %v", i, q.clients, q.waiting)
+		}
+	}
+}
+
+// waitQueued waits until n tickets are queued in s.
+func waitQueued(t *testing.T, s *Scheduler, n int) {
+	for i := 0; s.Len() != n; i++ {
+		if i > 1000 {
+			t.Fatalf("%d tickets queued; want %d", s.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSchedulerFairness(t *testing.T) {
+	s := NewScheduler(1)
+	first := &Ticket{Client: "x"}
+	if err := s.Wait(first); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan string)
+	tickets := []*Ticket{
+		{Client: "a"},
+		{Client: "a"},
+		{Client: "a"},
+		{Client: "b"},
+		{Client: "c", Priority: true},
+	}
+	names := []string{"a1", "a2", "a3", "b1", "c1"}
+	for i, tk := range tickets {
+		go func(tk *Ticket, name string) {
+			if err := s.Wait(tk); err != nil {
+				t.Error(err)
+			}
+			started <- name
+		}(tk, names[i])
+		waitQueued(t, s, i+1)
+	}
+
+	s.Done(first)
+	var order []string
+	for i := range tickets {
+		name := <-started
+		order = append(order, name)
+		for j, n := range names {
+			if n == name {
+				if l := s.Len(); l != len(tickets)-i-1 {
+					t.Errorf("Len = %d after %s started", l, name)
+				}
+				s.Done(tickets[j])
+			}
+		}
+	}
+	if o := strings.Join(order, ","); o != "c1,a1,b1,a2,a3" {
+		t.Errorf("order = %s; want c1,a1,b1,a2,a3", o)
+	}
+}
+
+func TestSchedulerNotify(t *testing.T) {
+	s := NewScheduler(1)
+	first := &Ticket{Client: "x"}
+	s.Wait(first)
+
+	positions := make(chan int, 10)
+	tk := &Ticket{Client: "a", Notify: func(st QueueStatus) {
+		positions <- st.Position
+	}}
+	done := make(chan error)
+	go func() { done <- s.Wait(tk) }()
+	if p := <-positions; p != 1 {
+		t.Errorf("position = %d; want 1", p)
+	}
+	s.Done(first)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	s.Done(tk)
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := NewScheduler(1)
+	first := &Ticket{Client: "x"}
+	s.Wait(first)
+
+	cancel := make(chan struct{})
+	tk := &Ticket{Client: "a", Cancel: cancel}
+	done := make(chan error)
+	go func() { done <- s.Wait(tk) }()
+	waitQueued(t, s, 1)
+	close(cancel)
+	if err := <-done; err != ErrClientGone {
+		t.Errorf("err = %v; want ErrClientGone", err)
+	}
+	if l := s.Len(); l != 0 {
+		t.Errorf("Len = %d after cancel", l)
+	}
+	s.Done(first)
+}
+
+// TestSchedulerCancelDispatched cancels tickets that are dispatched at the
+// same time. Whichever way Wait goes, the slot must be released.
+func TestSchedulerCancelDispatched(t *testing.T) {
+	s := NewScheduler(1)
+	for i := 0; i < 100; i++ {
+		cancel := make(chan struct{})
+		close(cancel)
+		tk := &Ticket{Client: "a", Cancel: cancel}
+		err := s.Wait(tk)
+		if err == nil {
+			s.Done(tk)
+		} else if err != ErrClientGone {
+			t.Fatal(err)
+		}
+
+		next := &Ticket{Client: "b"}
+		done := make(chan error, 1)
+		go func() { done <- s.Wait(next) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("slot of a cancelled ticket was not released")
+		}
+		s.Done(next)
+	}
+}
+
+func TestSchedulerClose(t *testing.T) {
+	s := NewScheduler(1)
+	first := &Ticket{Client: "x"}
+	s.Wait(first)
+
+	done := make(chan error)
+	go func() { done <- s.Wait(&Ticket{Client: "a"}) }()
+	waitQueued(t, s, 1)
+
+	s.Close()
+	if err := <-done; err != ErrShuttingDown {
+		t.Errorf("queued ticket: %v; want ErrShuttingDown", err)
+	}
+	if err := s.Wait(&Ticket{Client: "a"}); err != ErrShuttingDown {
+		t.Errorf("new ticket: %v; want ErrShuttingDown", err)
+	}
+	if s.Drain(time.Millisecond * 10) {
+		t.Error("Drain returned true with a running ticket")
+	}
+	s.Done(first)
+	if !s.Drain(time.Second) {
+		t.Error("Drain returned false with no running ticket")
+	}
+}
+
+var clientAddrTests = []struct {
+	remote    string
+	forwarded string
+	want      string
+}{
+	{"192.0.2.1:1234", "", "192.0.2.1"},
+	// Untrusted peers can't forge their address.
+	{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+	{"10.0.0.1:1234", "", "10.0.0.1"},
+	{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+	{"10.0.0.1:1234", "203.0.113.9, 198.51.100.1", "198.51.100.1"},
+	{"10.0.0.1:1234", "203.0.113.9, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+	{"10.0.0.1:1234", "198.51.100.1, 172.16.0.5", "198.51.100.1"},
+	{"172.16.0.5:1234", "198.51.100.1", "198.51.100.1"},
+	{"172.16.0.6:1234", "198.51.100.1", "172.16.0.6"},
+	{"10.0.0.1:1234", "10.0.0.2, 10.0.0.3", "10.0.0.2"},
+	{"10.0.0.1:1234", ", 198.51.100.1", "198.51.100.1"},
+	{"10.0.0.1:1234", "198.51.100.1, ", "10.0.0.1"},
+	{"[2001:db8::1]:1234", "198.51.100.1", "2001:db8::1"},
+	{"@", "198.51.100.1", "@"},
+}
+
+func TestClientAddr(t *testing.T) {
+	s := &Sango{conf: sango.Config{TrustedProxies: []string{"10.0.0.0/8", "172.16.0.5", "bogus"}}}
+	for i, tt := range clientAddrTests {
+		req := &http.Request{RemoteAddr: tt.remote, Header: make(http.Header)}
+		if len(tt.forwarded) > 0 {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if a := s.clientAddr(req); a != tt.want {
+			t.Errorf("#%d: clientAddr(%s, %q) = %s; want %s", i, tt.remote, tt.forwarded, a, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/h2so5/sango/src"
+)
+
+// stopWait bounds how long the runs are waited for after their containers
+// have been killed.
+const stopWait = time.Second * 10
+
+func (s *Sango) trackContainer(name string) {
+	s.containersMutex.Lock()
+	defer s.containersMutex.Unlock()
+	s.containers[name] = true
+}
+
+func (s *Sango) untrackContainer(name string) {
+	s.containersMutex.Lock()
+	defer s.containersMutex.Unlock()
+	delete(s.containers, name)
+}
+
+// stopContainers kills the containers started by this process that are
+// still running.
+func (s *Sango) stopContainers() {
+	s.containersMutex.Lock()
+	names := make([]string, 0, len(s.containers))
+	for name := range s.containers {
+		names = append(names, name)
+	}
+	s.containersMutex.Unlock()
+
+	for _, name := range names {
+		log.Printf("killing container %s", name)
+		err := sango.Docker.KillContainer(name)
+		if err != nil && !sango.IsDockerNotFound(err) {
+			log.Print(err)
+		}
+	}
+}
+
+// Shutdown stops accepting runs and waits for the running ones to finish
+// within grace. The remaining containers are killed after that. srv is
+// closed once the responses have been written, then the store is closed
+// unless some runs are still saving their results.
+func (s *Sango) Shutdown(srv *http.Server, grace time.Duration) {
+	s.sched.Close()
+
+	deadline := time.Now().Add(grace)
+	drained := s.sched.Drain(grace)
+	if !drained {
+		log.Print("grace period expired, stopping containers...")
+		s.stopContainers()
+		drained = s.sched.Drain(stopWait)
+	}
+
+	timeout := deadline.Sub(time.Now())
+	if timeout < stopWait {
+		timeout = stopWait
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		log.Print(err)
+	}
+
+	if !drained {
+		log.Print("runs did not finish, leaving the store open")
+		return
+	}
+	s.Close()
+}
@@ -11,6 +11,11 @@ import (
 
 const LimitedWriterSize = 1024 * 10
 
+const (
+	ArtifactSizeLimit  = 1024 * 256
+	ArtifactTotalLimit = 1024 * 1024
+)
+
 type MsgpackFilter struct {
 	Writer io.Writer
 	Tag    string
@@ -66,7 +71,11 @@ func Exec(command string, args []string, stdin io.Reader, rstdout, rstderr io.Wr
 		if exiterr, ok := err.(*exec.ExitError); ok {
 			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
 				code = status.ExitStatus()
-				signal = int(status.StopSignal())
+				if status.Signaled() {
+					signal = int(status.Signal())
+				} else {
+					signal = int(status.StopSignal())
+				}
 			}
 		}
 	}
@@ -94,23 +103,33 @@ func (l *LimitedWriter) Write(p []byte) (n int, err error) {
 	return
 }
 
-type Option struct {
-	Title      string        `yaml:"title"      json:"title"`
-	Type       string        `yaml:"type"       json:"type"`
-	Default    interface{}   `yaml:"default"    json:"default"`
-	Candidates []interface{} `yaml:"candidates" json:"candidates,omitempty"`
-}
-
 type Input struct {
-	Files   map[string]string      `json:"files"`
-	Stdin   string                 `json:"stdin"`
-	Options map[string]interface{} `json:"options,omitempty"`
+	Files       map[string]string      `json:"files"`
+	Main        string                 `json:"main,omitempty"`
+	BinaryFiles map[string][]byte      `json:"binary-files,omitempty"`
+	Artifacts   []string               `json:"artifacts,omitempty"`
+	Stdin       string                 `json:"stdin"`
+	Options     map[string]interface{} `json:"options,omitempty"`
+	Tests       []TestCase             `json:"tests,omitempty"`
+	Interactive bool                   `json:"interactive,omitempty"`
+	Limits      Limits                 `json:"-"`
 }
 
 type Output struct {
 	Results     map[string]ExecResult `json:"results"`
 	MixedOutput []Message             `json:"mixed-output"`
 	Status      string                `json:"status"`
+	Stages      []string              `json:"stages,omitempty"`
+	Cases       []CaseResult          `json:"cases,omitempty"`
+	Artifacts   []Artifact            `json:"artifacts,omitempty"`
+	Limits      Limits                `json:"limits"`
+}
+
+type Artifact struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Data      []byte `json:"-"`
 }
 
 type ExecResult struct {
@@ -122,7 +141,9 @@ type ExecResult struct {
 	Signal      int               `json:"signal"`
 	RunningTime float64           `json:"running-time"`
 	Timeout     bool              `json:"timeout"`
+	TimeLimit   float64           `json:"time-limit"`
 	Data        map[string]string `json:"data,omitempty"`
+	Diagnostics []Diagnostic      `json:"diagnostics,omitempty"`
 }
 
 type Rusage struct {
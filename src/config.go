@@ -10,23 +10,48 @@ import (
 
 type Config struct {
 	Port            uint16        `yaml:"port"`
+	Storage         string        `yaml:"storage"`
 	Database        string        `yaml:"database"`
+	RedisAddr       string        `yaml:"redis_addr"`
 	ImageDir        string        `yaml:"image_dir"`
 	UploadLimit     int64         `yaml:"upload_limit"`
 	ExecLimit       int           `yaml:"exec_limit"`
+	TestLimit       int           `yaml:"test_limit"`
+	StdinLimit      int           `yaml:"stdin_limit"`
 	CleanInterval   time.Duration `yaml:"clean_interval"`
+	LogRetention    time.Duration `yaml:"log_retention"`
+	SweepInterval   time.Duration `yaml:"sweep_interval"`
+	ShutdownGrace   time.Duration `yaml:"shutdown_grace"`
 	GoogleAnalytics string        `yaml:"google_analytics"`
+	Limits          Limits        `yaml:"limits"`
+	APITokens       []string      `yaml:"api_tokens"`
+	AdminTokens     []string      `yaml:"admin_tokens"`
+	TrustedProxies  []string      `yaml:"trusted_proxies"`
 }
 
 func defaultConfig() Config {
 	return Config{
 		Port:            3000,
+		Storage:         "redis",
 		Database:        "./sango.leveldb",
 		ImageDir:        "./images",
 		UploadLimit:     20480,
 		CleanInterval:   time.Minute,
+		LogRetention:    time.Hour * 24 * 30,
+		SweepInterval:   time.Hour,
+		ShutdownGrace:   time.Second * 30,
 		ExecLimit:       5,
+		TestLimit:       20,
+		StdinLimit:      16384,
 		GoogleAnalytics: "",
+		Limits: Limits{
+			Memory:          512 * 1024 * 1024,
+			CPUs:            1,
+			Pids:            64,
+			BuildTime:       5,
+			RunTime:         5,
+			InteractiveTime: 60,
+		},
 	}
 }
 
@@ -41,5 +66,9 @@ func LoadConfig(path string) Config {
 	} else {
 		log.Print(err)
 	}
+	if c.ExecLimit < 1 {
+		log.Printf("exec_limit must be at least 1; got %d", c.ExecLimit)
+		c.ExecLimit = 1
+	}
 	return c
 }
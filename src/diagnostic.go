@@ -0,0 +1,217 @@
+package sango
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNote    = "note"
+)
+
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Code     string `json:"code,omitempty"`
+}
+
+// DiagnosticAgent is implemented by agents whose tools report diagnostics
+// in one of the formats understood by ParseDiagnostics.
+type DiagnosticAgent interface {
+	DiagnosticFormat() string
+}
+
+var diagnosticParsers = map[string]func(string) []Diagnostic{
+	"gcc":      parseGCC,
+	"go":       parseGo,
+	"govet":    parseGoVet,
+	"php":      parsePHP,
+	"mruby":    parseMruby,
+	"cppcheck": parseCppcheck,
+}
+
+// ParseDiagnostics extracts the diagnostics from the output of a tool.
+// The format is one of "gcc" (also used by clang), "go", "govet", "php",
+// "mruby" and "cppcheck". The cppcheck output must use the template
+// CppcheckTemplate.
+func ParseDiagnostics(format, output string) []Diagnostic {
+	p, ok := diagnosticParsers[format]
+	if !ok {
+		return nil
+	}
+	var l []Diagnostic
+	found := make(map[Diagnostic]bool)
+	for _, d := range p(output) {
+		if !found[d] {
+			found[d] = true
+			l = append(l, d)
+		}
+	}
+	return l
+}
+
+var gccDiagnostic = regexp.MustCompile(`(?m)^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning|note): (.*?)(?: \[(-[^\]]+)\])?\r?$`)
+
+func parseGCC(output string) []Diagnostic {
+	var l []Diagnostic
+	for _, m := range gccDiagnostic.FindAllStringSubmatch(output, -1) {
+		severity := m[4]
+		if severity == "fatal error" {
+			severity = SeverityError
+		}
+		l = append(l, Diagnostic{
+			File:     m[1],
+			Line:     atoi(m[2]),
+			Column:   atoi(m[3]),
+			Severity: severity,
+			Message:  m[5],
+			Code:     m[6],
+		})
+	}
+	return l
+}
+
+var goDiagnostic = regexp.MustCompile(`(?m)^(.+?\.go):(\d+)(?::(\d+))?: (.*?)\r?$`)
+
+func parseGo(output string) []Diagnostic {
+	var l []Diagnostic
+	for _, m := range goDiagnostic.FindAllStringSubmatch(output, -1) {
+		l = append(l, Diagnostic{
+			File:     strings.TrimPrefix(m[1], "./"),
+			Line:     atoi(m[2]),
+			Column:   atoi(m[3]),
+			Severity: SeverityError,
+			Message:  m[4],
+		})
+	}
+	return l
+}
+
+var phpDiagnostic = regexp.MustCompile(`(?m)^(?:PHP )?(Parse error|Fatal error|Warning|Notice|Deprecated|Strict Standards): +(.*) in (.+) on line (\d+)\r?$`)
+
+func parsePHP(output string) []Diagnostic {
+	var l []Diagnostic
+	for _, m := range phpDiagnostic.FindAllStringSubmatch(output, -1) {
+		severity := SeverityNote
+		switch m[1] {
+		case "Parse error", "Fatal error":
+			severity = SeverityError
+		case "Warning":
+			severity = SeverityWarning
+		}
+		l = append(l, Diagnostic{
+			File:     m[3],
+			Line:     atoi(m[4]),
+			Severity: severity,
+			Message:  m[2],
+			Code:     m[1],
+		})
+	}
+	return l
+}
+
+var mrubyDiagnostic = regexp.MustCompile(`(?m)^(.+?\.rb):(\d+):(?:(\d+):)? (.*?)(?: \(([A-Z]\w*)\))?\r?$`)
+
+func parseMruby(output string) []Diagnostic {
+	var l []Diagnostic
+	for _, m := range mrubyDiagnostic.FindAllStringSubmatch(output, -1) {
+		l = append(l, Diagnostic{
+			File:     m[1],
+			Line:     atoi(m[2]),
+			Column:   atoi(m[3]),
+			Severity: SeverityError,
+			Message:  m[4],
+			Code:     m[5],
+		})
+	}
+	return l
+}
+
+func parseGoVet(output string) []Diagnostic {
+	l := parseGo(output)
+	for i := range l {
+		l[i].Severity = SeverityWarning
+	}
+	return l
+}
+
+const CppcheckTemplate = "{file}:{line}: {severity}: {message} [{id}]"
+
+// CppcheckArgs are the cppcheck arguments shared by every image, which
+// report the findings with CppcheckTemplate.
+var CppcheckArgs = []string{
+	"--enable=all",
+	"--quiet",
+	"--suppress=missingInclude",
+	"--suppress=missingIncludeSystem",
+	"--template=" + CppcheckTemplate,
+}
+
+var cppcheckDiagnostic = regexp.MustCompile(`(?m)^(.+?):(\d+): (\w+): (.*?)(?: \[(\w+)\])?\r?$`)
+
+func parseCppcheck(output string) []Diagnostic {
+	var l []Diagnostic
+	for _, m := range cppcheckDiagnostic.FindAllStringSubmatch(output, -1) {
+		severity := SeverityWarning
+		switch m[3] {
+		case "error":
+			severity = SeverityError
+		case "information":
+			severity = SeverityNote
+		}
+		l = append(l, Diagnostic{
+			File:     m[1],
+			Line:     atoi(m[2]),
+			Severity: severity,
+			Message:  m[4],
+			Code:     m[5],
+		})
+	}
+	return l
+}
+
+// parseDiagnostics returns the diagnostics in the output of r. Absolute
+// paths in the working directory are made relative.
+func parseDiagnostics(format string, r ExecResult) []Diagnostic {
+	if len(format) == 0 {
+		return nil
+	}
+	l := ParseDiagnostics(format, r.Stdout+"\n"+r.Stderr)
+	wd, err := os.Getwd()
+	if err != nil {
+		return l
+	}
+	for i := range l {
+		if filepath.IsAbs(l[i].File) {
+			if rel, err := filepath.Rel(wd, l[i].File); err == nil && !strings.HasPrefix(rel, "..") {
+				l[i].File = rel
+			}
+		}
+	}
+	return l
+}
+
+// Lint runs the action c with the linter command a and parses its output.
+// Findings don't make the action fail, only a linter that exits with an
+// error without reporting any.
+func Lint(c string, a []string, format string, in Input) (ExecResult, error) {
+	r, err := Jtime(a, c, in, nil)
+	r.Diagnostics = parseDiagnostics(format, r)
+	if err != nil && !r.Timeout && len(r.Diagnostics) > 0 {
+		err = nil
+	}
+	return r, err
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
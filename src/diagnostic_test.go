@@ -0,0 +1,164 @@
+package sango
+
+import (
+	"reflect"
+	"testing"
+)
+
+var diagnosticTests = []struct {
+	format string
+	output string
+	want   []Diagnostic
+}{
+	{
+		format: "gcc",
+		output: `main.c: In function 'main':
+main.c:3:5: warning: implicit declaration of function 'prinf' [-Wimplicit-function-declaration]
+     prinf("Hello World");
+     ^
+main.c:4:1: error: expected ';' before '}' token
+ }
+ ^
+`,
+		want: []Diagnostic{
+			{File: "main.c", Line: 3, Column: 5, Severity: SeverityWarning, Message: "implicit declaration of function 'prinf'", Code: "-Wimplicit-function-declaration"},
+			{File: "main.c", Line: 4, Column: 1, Severity: SeverityError, Message: "expected ';' before '}' token"},
+		},
+	},
+	{
+		format: "gcc",
+		output: `main.c:1:19: fatal error: foo.h: No such file or directory
+ #include <foo.h>
+                   ^
+compilation terminated.
+`,
+		want: []Diagnostic{
+			{File: "main.c", Line: 1, Column: 19, Severity: SeverityError, Message: "foo.h: No such file or directory"},
+		},
+	},
+	{
+		// gcc 4.4 and older print no column.
+		format: "gcc",
+		output: `main.c: In function 'main':
+main.c:4: error: 'x' undeclared (first use in this function)
+main.c:4: error: (Each undeclared identifier is reported only once
+`,
+		want: []Diagnostic{
+			{File: "main.c", Line: 4, Severity: SeverityError, Message: "'x' undeclared (first use in this function)"},
+			{File: "main.c", Line: 4, Severity: SeverityError, Message: "(Each undeclared identifier is reported only once"},
+		},
+	},
+	{
+		format: "gcc",
+		output: `In file included from main.c:1:0:
+main.c:5:10: warning: passing argument 1 of 'puts' makes pointer from integer without a cast [-Wint-conversion]
+   puts(42);
+        ^
+/usr/include/stdio.h:695:12: note: expected 'const char *' but argument is of type 'int'
+ extern int puts (const char *__s);
+            ^
+`,
+		want: []Diagnostic{
+			{File: "main.c", Line: 5, Column: 10, Severity: SeverityWarning, Message: "passing argument 1 of 'puts' makes pointer from integer without a cast", Code: "-Wint-conversion"},
+			{File: "/usr/include/stdio.h", Line: 695, Column: 12, Severity: SeverityNote, Message: "expected 'const char *' but argument is of type 'int'"},
+		},
+	},
+	{
+		// clang
+		format: "gcc",
+		output: "main.cpp:5:3: error: use of undeclared identifier 'foo'\r\n" +
+			"  foo();\r\n" +
+			"  ^\r\n" +
+			"main.cpp:3:7: warning: unused variable 'x' [-Wunused-variable]\r\n" +
+			"  int x;\r\n" +
+			"      ^\r\n" +
+			"1 warning and 1 error generated.\r\n",
+		want: []Diagnostic{
+			{File: "main.cpp", Line: 5, Column: 3, Severity: SeverityError, Message: "use of undeclared identifier 'foo'"},
+			{File: "main.cpp", Line: 3, Column: 7, Severity: SeverityWarning, Message: "unused variable 'x'", Code: "-Wunused-variable"},
+		},
+	},
+	{
+		format: "go",
+		output: `# command-line-arguments
+./main.go:6: undefined: fmt.Prinln
+./main.go:7:2: x declared and not used
+`,
+		want: []Diagnostic{
+			{File: "main.go", Line: 6, Severity: SeverityError, Message: "undefined: fmt.Prinln"},
+			{File: "main.go", Line: 7, Column: 2, Severity: SeverityError, Message: "x declared and not used"},
+		},
+	},
+	{
+		// gofmt -e -l
+		format: "go",
+		output: `main.go:5:2: expected declaration, found 'IDENT' fmt
+main.go:7:1: expected declaration, found '}'
+other.go
+`,
+		want: []Diagnostic{
+			{File: "main.go", Line: 5, Column: 2, Severity: SeverityError, Message: "expected declaration, found 'IDENT' fmt"},
+			{File: "main.go", Line: 7, Column: 1, Severity: SeverityError, Message: "expected declaration, found '}'"},
+		},
+	},
+	{
+		format: "govet",
+		output: `main.go:8: arg x for printf verb %d of wrong type: string
+exit status 1
+`,
+		want: []Diagnostic{
+			{File: "main.go", Line: 8, Severity: SeverityWarning, Message: "arg x for printf verb %d of wrong type: string"},
+		},
+	},
+	{
+		format: "php",
+		output: `PHP Parse error:  syntax error, unexpected '}' in main.php on line 4
+Parse error: syntax error, unexpected '}' in main.php on line 4
+Errors parsing main.php
+PHP Warning:  Division by zero in /home/sango/main.php on line 3
+`,
+		want: []Diagnostic{
+			{File: "main.php", Line: 4, Severity: SeverityError, Message: "syntax error, unexpected '}'", Code: "Parse error"},
+			{File: "/home/sango/main.php", Line: 3, Severity: SeverityWarning, Message: "Division by zero", Code: "Warning"},
+		},
+	},
+	{
+		format: "php",
+		output: "No syntax errors detected in main.php\n",
+	},
+	{
+		format: "mruby",
+		output: `main.rb:3:3: syntax error, unexpected keyword_end, expecting $end
+main.rb:2: undefined method 'foo' for main (NoMethodError)
+`,
+		want: []Diagnostic{
+			{File: "main.rb", Line: 3, Column: 3, Severity: SeverityError, Message: "syntax error, unexpected keyword_end, expecting $end"},
+			{File: "main.rb", Line: 2, Severity: SeverityError, Message: "undefined method 'foo' for main", Code: "NoMethodError"},
+		},
+	},
+	{
+		format: "cppcheck",
+		output: `main.c:5: error: Array 'a[10]' accessed at index 10, which is out of bounds. [arrayIndexOutOfBounds]
+main.c:3: style: The scope of the variable 'i' can be reduced. [variableScope]
+main.c:0: information: Cppcheck cannot find all the include files (use --check-config for details) [missingIncludeSystem]
+`,
+		want: []Diagnostic{
+			{File: "main.c", Line: 5, Severity: SeverityError, Message: "Array 'a[10]' accessed at index 10, which is out of bounds.", Code: "arrayIndexOutOfBounds"},
+			{File: "main.c", Line: 3, Severity: SeverityWarning, Message: "The scope of the variable 'i' can be reduced.", Code: "variableScope"},
+			{File: "main.c", Line: 0, Severity: SeverityNote, Message: "Cppcheck cannot find all the include files (use --check-config for details)", Code: "missingIncludeSystem"},
+		},
+	},
+	{
+		format: "unknown",
+		output: "main.c:1:1: error: oops\n",
+	},
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	for i, tt := range diagnosticTests {
+		got := ParseDiagnostics(tt.format, tt.output)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d %s: got %+v; want %+v", i, tt.format, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package sango
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const dockerAPIVersion = "1.24"
+
+const (
+	// dockerTimeout bounds every request to the daemon except for waiting
+	// for a container and pulling an image.
+	dockerTimeout     = time.Second * 30
+	dockerDialTimeout = time.Second * 5
+)
+
+var Docker = NewDockerClient(dockerAddr)
+
+type DockerClient struct {
+	Addr string
+
+	// ErrorHook is called with every error returned by the daemon except
+	// for missing objects.
+	ErrorHook func(error)
+
+	client *http.Client
+	// stream has no timeout, for the requests that last as long as a
+	// container or a pull.
+	stream *http.Client
+}
+
+func NewDockerClient(addr string) *DockerClient {
+	c := &DockerClient{Addr: addr}
+	t := &http.Transport{
+		Dial: func(network, a string) (net.Conn, error) {
+			return c.dial()
+		},
+	}
+	c.client = &http.Client{Transport: t, Timeout: dockerTimeout}
+	c.stream = &http.Client{Transport: t}
+	return c
+}
+
+type DockerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e DockerError) Error() string {
+	return fmt.Sprintf("docker: %s (%d)", e.Message, e.StatusCode)
+}
+
+func IsDockerNotFound(err error) bool {
+	e, ok := err.(DockerError)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+type ContainerConfig struct {
+	Image           string
+	Cmd             []string
+	AttachStdin     bool
+	AttachStdout    bool
+	AttachStderr    bool
+	OpenStdin       bool
+	StdinOnce       bool
+	NetworkDisabled bool
+	HostConfig      HostConfig
+}
+
+type HostConfig struct {
+	NetworkMode string
+	Memory      int64             `json:",omitempty"`
+	MemorySwap  int64             `json:",omitempty"`
+	CpuPeriod   int64             `json:",omitempty"`
+	CpuQuota    int64             `json:",omitempty"`
+	PidsLimit   int64             `json:",omitempty"`
+	StorageOpt  map[string]string `json:",omitempty"`
+}
+
+type ContainerState struct {
+	Running   bool
+	OOMKilled bool
+	ExitCode  int
+}
+
+type APIContainerInfo struct {
+	ID    string `json:"Id"`
+	Name  string
+	State ContainerState
+}
+
+type APIContainer struct {
+	ID     string `json:"Id"`
+	Image  string
+	Names  []string
+	State  string
+	Status string
+}
+
+type APIImage struct {
+	ID       string `json:"Id"`
+	RepoTags []string
+}
+
+func (c *DockerClient) dial() (net.Conn, error) {
+	return net.DialTimeout("unix", c.Addr, dockerDialTimeout)
+}
+
+func (c *DockerClient) url(path string, query url.Values) string {
+	u := "http://docker/v" + dockerAPIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (c *DockerClient) do(method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	return c.send(c.client, method, path, query, body)
+}
+
+func (c *DockerClient) send(client *http.Client, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.url(path, query), r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		c.hook(err)
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		err := readDockerError(resp)
+		c.hook(err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *DockerClient) hook(err error) {
+	if c.ErrorHook != nil && !IsDockerNotFound(err) {
+		c.ErrorHook(err)
+	}
+}
+
+func (c *DockerClient) call(method, path string, query url.Values, body, result interface{}) error {
+	resp, err := c.do(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, result)
+}
+
+func decodeResponse(resp *http.Response, result interface{}) error {
+	defer resp.Body.Close()
+	if result == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func readDockerError(resp *http.Response) error {
+	data, _ := ioutil.ReadAll(resp.Body)
+	var e struct {
+		Message string `json:"message"`
+	}
+	msg := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &e) == nil && len(e.Message) > 0 {
+		msg = e.Message
+	}
+	return DockerError{StatusCode: resp.StatusCode, Message: msg}
+}
+
+func (c *DockerClient) Ping() error {
+	return c.call("GET", "/_ping", nil, nil, nil)
+}
+
+func (c *DockerClient) CreateContainer(name string, conf ContainerConfig) (string, error) {
+	var q url.Values
+	if len(name) > 0 {
+		q = url.Values{"name": {name}}
+	}
+	var res struct {
+		ID string `json:"Id"`
+	}
+	err := c.call("POST", "/containers/create", q, conf, &res)
+	return res.ID, err
+}
+
+func (c *DockerClient) StartContainer(id string) error {
+	return c.call("POST", "/containers/"+id+"/start", nil, nil, nil)
+}
+
+func (c *DockerClient) WaitContainer(id string) (int, error) {
+	var res struct {
+		StatusCode int
+	}
+	resp, err := c.send(c.stream, "POST", "/containers/"+id+"/wait", nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	err = decodeResponse(resp, &res)
+	return res.StatusCode, err
+}
+
+func (c *DockerClient) InspectContainer(id string) (APIContainerInfo, error) {
+	var info APIContainerInfo
+	err := c.call("GET", "/containers/"+id+"/json", nil, nil, &info)
+	return info, err
+}
+
+func (c *DockerClient) KillContainer(id string) error {
+	return c.call("POST", "/containers/"+id+"/kill", nil, nil, nil)
+}
+
+func (c *DockerClient) RemoveContainer(id string, force bool) error {
+	q := url.Values{}
+	if force {
+		q.Set("force", "1")
+	}
+	return c.call("DELETE", "/containers/"+id, q, nil, nil)
+}
+
+func (c *DockerClient) ListContainers(all bool) ([]APIContainer, error) {
+	q := url.Values{}
+	if all {
+		q.Set("all", "1")
+	}
+	var l []APIContainer
+	err := c.call("GET", "/containers/json", q, nil, &l)
+	return l, err
+}
+
+func (c *DockerClient) ListImages() ([]APIImage, error) {
+	var l []APIImage
+	err := c.call("GET", "/images/json", nil, nil, &l)
+	return l, err
+}
+
+func (c *DockerClient) PullImage(name string) error {
+	tag := "latest"
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	resp, err := c.send(c.stream, "POST", "/images/create", url.Values{"fromImage": {name}, "tag": {tag}}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The daemon reports pull failures inside the progress stream.
+	d := json.NewDecoder(resp.Body)
+	for {
+		var m struct {
+			Error string `json:"error"`
+		}
+		err := d.Decode(&m)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(m.Error) > 0 {
+			return DockerError{StatusCode: resp.StatusCode, Message: m.Error}
+		}
+	}
+}
+
+type hijackedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (h *hijackedConn) Read(p []byte) (int, error) {
+	return h.r.Read(p)
+}
+
+func (h *hijackedConn) CloseWrite() error {
+	if c, ok := h.Conn.(*net.UnixConn); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+func (c *DockerClient) attachContainer(id string) (*hijackedConn, error) {
+	q := url.Values{"stream": {"1"}, "stdin": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	req, err := http.NewRequest("POST", c.url("/containers/"+id+"/attach", q), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := c.dial()
+	if err != nil {
+		c.hook(err)
+		return nil, err
+	}
+	// Only the handshake is bounded; the stream lasts as long as the
+	// container.
+	conn.SetDeadline(time.Now().Add(dockerTimeout))
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		c.hook(err)
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		c.hook(err)
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	if resp.StatusCode >= 400 {
+		defer conn.Close()
+		err := readDockerError(resp)
+		c.hook(err)
+		return nil, err
+	}
+	return &hijackedConn{Conn: conn, r: br}, nil
+}
+
+// demuxStream splits the multiplexed attach stream of a container started
+// without a tty into stdout and stderr.
+func demuxStream(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		if w == nil {
+			w = ioutil.Discard
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		_, err = io.CopyN(w, r, size)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Run creates a container, streams stdin into it and waits until it exits
+// or the timeout expires. The exit code of the container is returned.
+func (c *DockerClient) Run(name string, conf ContainerConfig, stdin io.Reader, stdout, stderr io.Writer, timeout time.Duration) (int, error) {
+	conf.AttachStdin = true
+	conf.AttachStdout = true
+	conf.AttachStderr = true
+	conf.OpenStdin = true
+	conf.StdinOnce = true
+
+	id, err := c.CreateContainer(name, conf)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := c.attachContainer(id)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	streamch := make(chan error, 1)
+	go func() {
+		streamch <- demuxStream(conn, stdout, stderr)
+	}()
+
+	err = c.StartContainer(id)
+	if err != nil {
+		return 0, err
+	}
+
+	go func() {
+		if stdin != nil {
+			io.Copy(conn, stdin)
+		}
+		conn.CloseWrite()
+	}()
+
+	type waitResult struct {
+		code int
+		err  error
+	}
+	waitch := make(chan waitResult, 1)
+	go func() {
+		code, err := c.WaitContainer(id)
+		waitch <- waitResult{code, err}
+	}()
+
+	var timech <-chan time.Time
+	if timeout != 0 {
+		timech = time.After(timeout)
+	}
+
+	var res waitResult
+	var timeouterr bool
+	select {
+	case <-timech:
+		c.KillContainer(id)
+		select {
+		case res = <-waitch:
+		case <-time.After(dockerTimeout):
+			res.err = errors.New("docker: container did not stop after kill")
+		}
+		timeouterr = true
+	case res = <-waitch:
+	}
+
+	if res.err != nil {
+		conn.Close()
+	}
+	<-streamch
+
+	if timeouterr {
+		return res.code, TimeoutError{}
+	}
+	return res.code, res.err
+}
@@ -0,0 +1,232 @@
+package sango
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDocker serves the part of the Engine API used by Run. A container
+// started with "echo" writes its stdin to stdout and "err" to stderr, then
+// exits. A container started with "sleep" runs until it is killed.
+type fakeDocker struct {
+	mutex      sync.Mutex
+	cmd        []string
+	exited     chan int
+	calls      []string
+	pingDelay  time.Duration
+	attachWait sync.WaitGroup
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v"+dockerAPIVersion)
+	f.mutex.Lock()
+	f.calls = append(f.calls, r.Method+" "+path)
+	f.mutex.Unlock()
+
+	switch path {
+	case "/_ping":
+		time.Sleep(f.pingDelay)
+		io.WriteString(w, "OK")
+	case "/containers/create":
+		var conf ContainerConfig
+		json.NewDecoder(r.Body).Decode(&conf)
+		f.mutex.Lock()
+		f.cmd = conf.Cmd
+		f.exited = make(chan int, 1)
+		f.mutex.Unlock()
+		w.WriteHeader(201)
+		io.WriteString(w, `{"Id":"c1"}`)
+	case "/containers/c1/attach":
+		f.attach(w)
+	case "/containers/c1/start":
+		w.WriteHeader(204)
+	case "/containers/c1/wait":
+		code := <-f.exited
+		f.attachWait.Wait()
+		io.WriteString(w, `{"StatusCode":`+strconv.Itoa(code)+`}`)
+	case "/containers/c1/kill":
+		f.exited <- 137
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(404)
+		io.WriteString(w, `{"message":"no such container"}`)
+	}
+}
+
+func (f *fakeDocker) attach(w http.ResponseWriter) {
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	f.attachWait.Add(1)
+	rw.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	rw.Flush()
+
+	go func() {
+		defer f.attachWait.Done()
+		defer conn.Close()
+		stdin, _ := ioutil.ReadAll(rw)
+		f.mutex.Lock()
+		cmd := f.cmd
+		f.mutex.Unlock()
+		if cmd[0] == "echo" {
+			writeFrame(conn, 1, stdin)
+			writeFrame(conn, 2, []byte("err"))
+			f.exited <- 3
+		}
+	}()
+}
+
+func writeFrame(w io.Writer, stream byte, data []byte) {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	w.Write(append(header, data...))
+}
+
+func startFakeDocker(t *testing.T) (*fakeDocker, *DockerClient, func()) {
+	dir, err := ioutil.TempDir("", "sango-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeDocker{}
+	go http.Serve(l, f)
+	return f, NewDockerClient(addr), func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDockerRun(t *testing.T) {
+	f, c, stop := startFakeDocker(t)
+	defer stop()
+
+	var stdout, stderr bytes.Buffer
+	code, err := c.Run("", ContainerConfig{Cmd: []string{"echo"}}, strings.NewReader("Hello World"), &stdout, &stderr, time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 {
+		t.Errorf("code = %d; want 3", code)
+	}
+	if stdout.String() != "Hello World" {
+		t.Errorf("stdout = %q; want %q", stdout.String(), "Hello World")
+	}
+	if stderr.String() != "err" {
+		t.Errorf("stderr = %q; want %q", stderr.String(), "err")
+	}
+
+	want := []string{
+		"POST /containers/create",
+		"POST /containers/c1/attach",
+		"POST /containers/c1/start",
+		"POST /containers/c1/wait",
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if strings.Join(f.calls, ",") != strings.Join(want, ",") {
+		t.Errorf("calls = %v; want %v", f.calls, want)
+	}
+}
+
+func TestDockerRunTimeout(t *testing.T) {
+	f, c, stop := startFakeDocker(t)
+	defer stop()
+
+	start := time.Now()
+	code, err := c.Run("", ContainerConfig{Cmd: []string{"sleep"}}, nil, nil, nil, time.Millisecond*100)
+	if _, ok := err.(TimeoutError); !ok {
+		t.Fatalf("err = %v; want TimeoutError", err)
+	}
+	if code != 137 {
+		t.Errorf("code = %d; want 137", code)
+	}
+	if d := time.Since(start); d > time.Second*2 {
+		t.Errorf("Run took %v after the timeout", d)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	killed := false
+	for _, c := range f.calls {
+		killed = killed || c == "POST /containers/c1/kill"
+	}
+	if !killed {
+		t.Errorf("container not killed: %v", f.calls)
+	}
+}
+
+func TestDockerError(t *testing.T) {
+	_, c, stop := startFakeDocker(t)
+	defer stop()
+
+	_, err := c.InspectContainer("c2")
+	if !IsDockerNotFound(err) {
+		t.Errorf("err = %v; want not found", err)
+	}
+	if e, ok := err.(DockerError); !ok || e.Message != "no such container" {
+		t.Errorf("err = %#v", err)
+	}
+}
+
+func TestDockerPingTimeout(t *testing.T) {
+	f, c, stop := startFakeDocker(t)
+	defer stop()
+
+	f.pingDelay = time.Second
+	c.client.Timeout = time.Millisecond * 100
+	if err := c.Ping(); err == nil {
+		t.Error("Ping of a hung daemon succeeded")
+	}
+}
+
+func TestDemuxStream(t *testing.T) {
+	var in bytes.Buffer
+	writeFrame(&in, 1, []byte("out1"))
+	writeFrame(&in, 2, []byte("err"))
+	writeFrame(&in, 1, nil)
+	writeFrame(&in, 1, []byte("out2"))
+
+	var stdout, stderr bytes.Buffer
+	err := demuxStream(bufio.NewReader(&in), &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "out1out2" || stderr.String() != "err" {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+
+	in.Reset()
+	writeFrame(&in, 2, []byte("dropped"))
+	writeFrame(&in, 1, []byte("kept"))
+	stdout.Reset()
+	err = demuxStream(&in, &stdout, nil)
+	if err != nil || stdout.String() != "kept" {
+		t.Errorf("stdout = %q, err = %v", stdout.String(), err)
+	}
+
+	in.Reset()
+	writeFrame(&in, 1, []byte("truncated"))
+	in.Truncate(in.Len() - 2)
+	if err := demuxStream(&in, ioutil.Discard, nil); err == nil {
+		t.Error("truncated frame accepted")
+	}
+}
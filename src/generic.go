@@ -0,0 +1,243 @@
+package sango
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// AgentConfig describes an agent declaratively in the agent section of
+// config.yml. Every command is a list of arguments and each argument is a
+// text/template with the following functions:
+//
+//	option "name"  the value of the option, or nothing if it is not set;
+//	               list values such as flags become separate arguments
+//	files "ext"... the input files with one of the extensions (all files
+//	               if none is given), each as a separate argument
+//	sources        the input files with one of the extensions of the image
+//	main           the main file, or the first source file
+//	cppcheckArgs   the arguments of cppcheck in CppcheckArgs
+//
+// Arguments that expand to an empty string are dropped.
+//
+// Stages replaces Build and Run with an arbitrary pipeline.
+type AgentConfig struct {
+	Build       []string               `yaml:"build"`
+	Run         []string               `yaml:"run"`
+	Stages      []AgentStage           `yaml:"stages"`
+	Version     []string               `yaml:"version"`
+	Actions     map[string]AgentAction `yaml:"actions"`
+	Diagnostics string                 `yaml:"diagnostics"`
+	Test        AgentTest              `yaml:"test"`
+}
+
+// AgentAction is an action command. The diagnostics in its output are
+// parsed if Diagnostics is set, and the files are returned in the data of
+// the result if Rewrite is set.
+type AgentAction struct {
+	Command     []string `yaml:"command"`
+	Diagnostics string   `yaml:"diagnostics"`
+	Rewrite     bool     `yaml:"rewrite"`
+}
+
+type AgentStage struct {
+	Name        string   `yaml:"name"`
+	Command     []string `yaml:"command"`
+	Continue    bool     `yaml:"continue"`
+	Timeout     float64  `yaml:"timeout"`
+	Status      string   `yaml:"status"`
+	Diagnostics string   `yaml:"diagnostics"`
+}
+
+type AgentTest struct {
+	Files  []string `yaml:"files"`
+	Stdin  string   `yaml:"stdin"`
+	Stdout string   `yaml:"stdout"`
+}
+
+// GenericAgent is an Agent driven by an AgentConfig.
+type GenericAgent struct {
+	AgentBase
+	Config     AgentConfig
+	Extensions []string
+}
+
+func LoadGenericAgent(path string) (GenericAgent, error) {
+	img, err := loadImageConfig(path)
+	if err != nil {
+		return GenericAgent{}, err
+	}
+	if img.Agent == nil {
+		return GenericAgent{}, errors.New("no agent section in " + path)
+	}
+	return GenericAgent{Config: *img.Agent, Extensions: img.Extensions}, nil
+}
+
+func (a GenericAgent) BuildCommand(in Input) ([]string, error) {
+	if len(a.Config.Build) == 0 {
+		return nil, errors.New("unknown command")
+	}
+	return a.expand(a.Config.Build, in)
+}
+
+func (a GenericAgent) RunCommand(in Input) ([]string, error) {
+	if len(a.Config.Run) == 0 {
+		return nil, errors.New("no run command")
+	}
+	return a.expand(a.Config.Run, in)
+}
+
+func (a GenericAgent) Stages(in Input) ([]Stage, error) {
+	if len(a.Config.Stages) == 0 {
+		return defaultStages(a, in)
+	}
+	var stages []Stage
+	for _, s := range a.Config.Stages {
+		args, err := a.expand(s.Command, in)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, Stage{
+			Name:        s.Name,
+			Command:     args,
+			Continue:    s.Continue,
+			Timeout:     s.Timeout,
+			Status:      s.Status,
+			Diagnostics: s.Diagnostics,
+		})
+	}
+	return stages, nil
+}
+
+func (a GenericAgent) DiagnosticFormat() string {
+	return a.Config.Diagnostics
+}
+
+func (a GenericAgent) ActionCommands(in Input) (map[string][]string, error) {
+	c := make(map[string][]string)
+	for k, t := range a.Config.Actions {
+		args, err := a.expand(t.Command, in)
+		if err != nil {
+			return nil, err
+		}
+		c[k] = args
+	}
+	return c, nil
+}
+
+func (a GenericAgent) Action(c string, in Input) (ExecResult, error) {
+	t, ok := a.Config.Actions[c]
+	if !ok {
+		return ExecResult{}, errors.New("unknown command")
+	}
+	args, err := a.expand(t.Command, in)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	var r ExecResult
+	if len(t.Diagnostics) > 0 {
+		r, err = Lint(c, args, t.Diagnostics, in)
+	} else {
+		r, err = Jtime(args, c, in, nil)
+	}
+	if t.Rewrite {
+		r.Data = readFiles(in)
+	}
+	return r, err
+}
+
+// Format runs the action c with the formatter command a, which rewrites
+// the files in place, and returns the files in the data of the result.
+func Format(c string, a []string, in Input) (ExecResult, error) {
+	r, err := Jtime(a, c, in, nil)
+	r.Data = readFiles(in)
+	return r, err
+}
+
+func readFiles(in Input) map[string]string {
+	files := map[string]string{}
+	for k := range in.Files {
+		data, err := ioutil.ReadFile(k)
+		if err == nil {
+			files[k] = string(data)
+		}
+	}
+	return files
+}
+
+func (a GenericAgent) Version() string {
+	args, err := a.expand(a.Config.Version, Input{})
+	if err != nil || len(args) == 0 {
+		return ""
+	}
+	stdout, stderr := System(".", "", args[0], args[1:]...)
+	if len(strings.TrimSpace(stdout)) == 0 {
+		return stderr
+	}
+	return stdout
+}
+
+func (a GenericAgent) Test() (map[string]string, string, string) {
+	files := make(map[string]string)
+	for _, f := range a.Config.Test.Files {
+		files[f] = ""
+	}
+	return files, a.Config.Test.Stdin, a.Config.Test.Stdout
+}
+
+// argSep separates the arguments of a list placeholder in an expanded
+// template.
+const argSep = "\x00"
+
+func (a GenericAgent) expand(t []string, in Input) ([]string, error) {
+	funcs := template.FuncMap{
+		"option": func(name string) interface{} {
+			switch v := in.Options[name].(type) {
+			case nil:
+				return ""
+			case []interface{}:
+				l := make([]string, len(v))
+				for i := range v {
+					l[i] = fmt.Sprint(v[i])
+				}
+				return strings.Join(l, argSep)
+			default:
+				return v
+			}
+		},
+		"files": func(ext ...string) string {
+			return strings.Join(SourceFiles(in, ext...), argSep)
+		},
+		"sources": func() string {
+			return strings.Join(SourceFiles(in, a.Extensions...), argSep)
+		},
+		"main": func() string {
+			return MainFile(in, a.Extensions...)
+		},
+		"cppcheckArgs": func() string {
+			return strings.Join(CppcheckArgs, argSep)
+		},
+	}
+
+	var args []string
+	for _, s := range t {
+		tmpl, err := template.New("").Funcs(funcs).Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		var b bytes.Buffer
+		err = tmpl.Execute(&b, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range strings.Split(b.String(), argSep) {
+			if len(a) > 0 {
+				args = append(args, a)
+			}
+		}
+	}
+	return args, nil
+}
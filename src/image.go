@@ -3,14 +3,12 @@ package sango
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"math/big"
 	"math/rand"
 	"net/http"
-	"os"
-	"os/exec"
-	"regexp"
 	"strings"
 	"time"
 
@@ -22,61 +20,83 @@ const dockerAddr = "/var/run/docker.sock"
 const imagePrefix = "sango/"
 const hubImageListEndpoint = "https://index.docker.io/v1/search?q=" + imagePrefix
 
+// agentTimeout bounds the agent subcommands that don't run any code.
+const agentTimeout = time.Second * 30
+
 type Image struct {
-	ID         string            `yaml:"id"         json:"id"`
-	Name       string            `yaml:"name"       json:"name"`
-	Language   string            `yaml:"language"   json:"language"`
-	Options    map[string]Option `yaml:"options"    json:"options,omitempty"`
-	Actions    []string          `yaml:"-"          json:"actions"`
-	Version    string            `yaml:"-"          json:"version"`
-	Protocol   int               `yaml:"-"          json:"-"`
-	Template   string            `yaml:"-"          json:"-"`
-	HelloWorld string            `yaml:"-"          json:"-"`
-	Extensions []string          `yaml:"extensions" json:"extensions"`
-	AceMode    string            `yaml:"acemode"    json:"-"`
+	ID          string            `yaml:"id"         json:"id"`
+	Name        string            `yaml:"name"       json:"name"`
+	Language    string            `yaml:"language"   json:"language"`
+	Options     map[string]Option `yaml:"options"    json:"options,omitempty"`
+	Actions     []string          `yaml:"-"          json:"actions"`
+	Version     string            `yaml:"-"          json:"version"`
+	Stages      []Stage           `yaml:"-"          json:"-"`
+	Protocol    int               `yaml:"-"          json:"-"`
+	ProtocolMin int               `yaml:"-"          json:"-"`
+	Warnings    []string          `yaml:"-"          json:"warnings,omitempty"`
+	Template    string            `yaml:"-"          json:"-"`
+	HelloWorld  string            `yaml:"-"          json:"-"`
+	Extensions  []string          `yaml:"extensions" json:"extensions"`
+	AceMode     string            `yaml:"acemode"    json:"-"`
+	Limits      Limits            `yaml:"limits"     json:"limits"`
+	Agent       *AgentConfig      `yaml:"agent"      json:"-"`
 }
 
 func (i Image) dockerImageName() string {
 	return imagePrefix + i.ID
 }
 
+func (i Image) containerConfig(cmd ...string) ContainerConfig {
+	return ContainerConfig{
+		Image:           i.dockerImageName(),
+		Cmd:             cmd,
+		NetworkDisabled: true,
+		HostConfig: HostConfig{
+			NetworkMode: "none",
+		},
+	}
+}
+
 func (i *Image) GetInfo() error {
 	var stdout bytes.Buffer
-	cmd := exec.Command("docker", "run", "-i", "--net=none", i.dockerImageName(), "agent", "version")
-	cmd.Stdout = &stdout
-	err := cmd.Run()
+	code, err := Docker.Run("", i.containerConfig("agent", "version"), nil, &stdout, nil, agentTimeout)
+	if err != nil {
+		return err
+	} else if code != 0 {
+		return fmt.Errorf("agent version exited with code %d", code)
+	}
 
+	err = msgpack.Unmarshal(stdout.Bytes(), i)
 	if err != nil {
 		return err
-	} else {
-		err := msgpack.Unmarshal(stdout.Bytes(), i)
-		if err != nil {
-			return err
-		}
 	}
-	return nil
+	return i.CompileOptions()
 }
 
 func (i *Image) GetCommand(in Input) (map[string]string, error) {
 	var c map[string]string
-	data, err := msgpack.Marshal(in)
+	options, err := i.NormalizeOptions(in.Options)
+	if err != nil {
+		return c, err
+	}
+	in.Options = options
+
+	data, err := i.encodeInput(in)
 	if err != nil {
 		return c, err
 	}
 
 	var stdout bytes.Buffer
-	cmd := exec.Command("docker", "run", "-i", "--net=none", i.dockerImageName(), "agent", "cmd")
-	cmd.Stdin = bytes.NewBuffer(data)
-	cmd.Stdout = &stdout
-	err = cmd.Run()
+	code, err := Docker.Run("", i.containerConfig("agent", "cmd"), bytes.NewReader(data), &stdout, nil, agentTimeout)
+	if err != nil {
+		return c, err
+	} else if code != 0 {
+		return c, fmt.Errorf("agent cmd exited with code %d", code)
+	}
 
+	err = msgpack.Unmarshal(stdout.Bytes(), &c)
 	if err != nil {
 		return c, err
-	} else {
-		err := msgpack.Unmarshal(stdout.Bytes(), &c)
-		if err != nil {
-			return c, err
-		}
 	}
 	return c, nil
 }
@@ -85,57 +105,42 @@ func GenerateID() string {
 	return string(base58.EncodeBig(nil, big.NewInt(0).Add(big.NewInt(0xc0ffee), big.NewInt(rand.Int63()))))
 }
 
-func (i Image) Exec(act string, in Input, msgch chan<- *Message) (Output, error) {
-	data, err := msgpack.Marshal(in)
+// Exec runs the agent subcommand act in a new container called name. If
+// stdin is not nil, it is streamed to the agent after the input.
+//
+// The container lives as long as the stages reported by the agent may
+// take, and the agent cuts the pipeline short at limits.Time so that the
+// output is returned before the container is killed.
+func (i Image) Exec(name, act string, in Input, limits Limits, stdin io.Reader, msgch chan<- *Message) (Output, error) {
+	stages := i.Stages
+	if act != "run" {
+		stages = []Stage{{Name: act}}
+	} else if in.Interactive {
+		limits = limits.interactive()
+	}
+	limits.Time = limits.pipelineTime(stages, len(in.Tests))
+	in.Limits = limits
+	options, err := i.NormalizeOptions(in.Options)
 	if err != nil {
 		return Output{}, err
 	}
-	id := GenerateID()
-
-	if in.Options == nil {
-		in.Options = make(map[string]interface{})
-	}
+	in.Options = options
 
-	for k, v := range i.Options {
-		if _, ok := in.Options[k]; !ok {
-			in.Options[k] = v.Default
-		}
-		o := in.Options[k]
-		in.Options[k] = v.Default
-		switch v.Type {
-		case "list":
-			if s, ok := o.(string); ok {
-				for _, i := range v.Candidates {
-					if s == i.(string) {
-						in.Options[k] = s
-					}
-				}
-			}
-		case "bool":
-			if b, ok := o.(bool); ok {
-				in.Options[k] = b
-			}
-		}
+	data, err := i.encodeInput(in)
+	if err != nil {
+		return Output{}, err
 	}
 
 	var stdout bytes.Buffer
 	r, w := io.Pipe()
-	cmd := exec.Command("docker", "run", "-i", "--name", id, "--net=none", i.dockerImageName(), "agent", act)
-	cmd.Stdin = bytes.NewReader(data)
-	cmd.Stdout = &stdout
-	cmd.Stderr = w
-	cmd.Start()
-
-	ch := make(chan error, 1)
-	go func() {
-		ch <- cmd.Wait()
-	}()
 
 	out := Output{
 		MixedOutput: make([]Message, 0),
 	}
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		d := msgpack.NewDecoder(r)
 		for {
 			var m Message
@@ -153,43 +158,52 @@ func (i Image) Exec(act string, in Input, msgch chan<- *Message) (Output, error)
 		}
 	}()
 
-	select {
-	case <-time.After(time.Second * 8):
-		stopcmd := exec.Command("docker", "stop", "--time=0", id)
-		stopcmd.Run()
-		err = <-ch
-	case err = <-ch:
+	conf := i.containerConfig("agent", act)
+	limits.apply(&conf.HostConfig)
+	var input io.Reader = bytes.NewReader(data)
+	if stdin != nil {
+		input = io.MultiReader(input, stdin)
+	}
+	code, err := Docker.Run(name, conf, input, &stdout, w, limits.containerTimeout())
+	if err == nil && code != 0 {
+		err = fmt.Errorf("agent exited with code %d", code)
 	}
 
-	r.Close()
 	w.Close()
+	<-done
+	r.Close()
 
 	if err != nil {
+		log.Print(err)
 		out.Status = "Internal error"
 	} else {
-		err = msgpack.Unmarshal(stdout.Bytes(), &out)
+		err = i.decodeOutput(stdout.Bytes(), &out)
 		if err != nil {
 			return Output{}, err
 		}
 	}
 
+	info, ierr := Docker.InspectContainer(name)
+	if ierr == nil && info.State.OOMKilled {
+		out.markOOM()
+	}
+	out.Limits = limits
+
 	return out, nil
 }
 
 func CleanImages() error {
-	var stdout bytes.Buffer
-	cmd := exec.Command("docker", "ps", "-a", "-q")
-	cmd.Stdout = &stdout
-	err := cmd.Run()
+	ps, err := Docker.ListContainers(true)
 	if err != nil {
 		return err
 	}
-	ps := strings.Split(string(stdout.Bytes()), "\n")
-	if len(ps) > 1 {
-		cmd := exec.Command("docker", append([]string{"rm"}, ps[:len(ps)-1]...)...)
-		err = cmd.Run()
-		if err != nil {
-			return err
+	for _, c := range ps {
+		if c.State == "running" {
+			continue
+		}
+		err := Docker.RemoveContainer(c.ID, false)
+		if err != nil && !IsDockerNotFound(err) {
+			log.Print(err)
 		}
 	}
 
@@ -197,14 +211,7 @@ func CleanImages() error {
 }
 
 func pullImage(image string) error {
-	cmd := exec.Command("docker", "pull", image)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		return err
-	}
-	return nil
+	return Docker.PullImage(image)
 }
 
 type HubImageList struct {
@@ -234,21 +241,26 @@ func getHubImages() ([]string, error) {
 	return l, nil
 }
 
-var idRegexp = regexp.MustCompile("^" + regexp.QuoteMeta(imagePrefix) + "[^_].+? ")
-
 func images() ([]string, error) {
-	var stdout bytes.Buffer
-	cmd := exec.Command("docker", "images")
-	cmd.Stdout = &stdout
-	err := cmd.Run()
+	list, err := Docker.ListImages()
 	if err != nil {
 		return nil, err
 	}
 	var i []string
-	for _, l := range strings.Split(stdout.String(), "\n") {
-		id := strings.Trim(strings.Replace(string(idRegexp.Find([]byte(l))), imagePrefix, "", -1), " ")
-		if len(id) > 0 {
-			i = append(i, id)
+	found := make(map[string]bool)
+	for _, img := range list {
+		for _, t := range img.RepoTags {
+			if !strings.HasPrefix(t, imagePrefix) {
+				continue
+			}
+			id := strings.TrimPrefix(t, imagePrefix)
+			if n := strings.LastIndex(id, ":"); n >= 0 {
+				id = id[:n]
+			}
+			if len(id) > 0 && !strings.HasPrefix(id, "_") && !found[id] {
+				found[id] = true
+				i = append(i, id)
+			}
 		}
 	}
 	return i, nil
@@ -256,15 +268,47 @@ func images() ([]string, error) {
 
 type ImageList map[string]Image
 
+// CompileOptions calls CompileOptions on every image, which is needed
+// after the list is decoded.
+func (l ImageList) CompileOptions() error {
+	for k, img := range l {
+		if err := img.CompileOptions(); err != nil {
+			return err
+		}
+		l[k] = img
+	}
+	return nil
+}
+
+// ImageCandidate is an image found by ScanImages. Error tells why it was
+// not loaded.
+type ImageCandidate struct {
+	ID       string `json:"id"`
+	Language string `json:"language,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Protocol int    `json:"protocol,omitempty"`
+	Loaded   bool   `json:"loaded"`
+	Error    string `json:"error,omitempty"`
+	Warning  string `json:"warning,omitempty"`
+}
+
 func MakeImageList(langpath string, pull bool) (ImageList, error) {
+	l, _, err := ScanImages(langpath, pull)
+	return l, err
+}
+
+// ScanImages is like MakeImageList but also returns every candidate image
+// including the rejected ones.
+func ScanImages(langpath string, pull bool) (ImageList, []ImageCandidate, error) {
 	l := make(ImageList)
+	var candidates []ImageCandidate
 
 	var imgs []string
 	var err error
 	if pull {
 		imgs, err = getHubImages()
 		if err != nil {
-			log.Print("Filed to get image list from the hub: %v", err)
+			log.Printf("Filed to get image list from the hub: %v", err)
 		} else {
 			log.Print("Get image list from the hub")
 		}
@@ -275,7 +319,7 @@ func MakeImageList(langpath string, pull bool) (ImageList, error) {
 	}
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	for _, i := range imgs {
@@ -286,20 +330,33 @@ func MakeImageList(langpath string, pull bool) (ImageList, error) {
 				log.Print(err)
 			}
 		}
+		c := ImageCandidate{ID: i}
 		err := img.GetInfo()
 		if err != nil {
 			log.Printf("Filed to get version: %v", err)
+			c.Error = "Failed to get version: " + err.Error()
 		} else {
-			if img.Protocol != ProtocolVersion {
-				log.Printf("Protocol version mismatch: %s (%s) %d", img.Language, img.Version, img.Protocol)
+			c.Language = img.Language
+			c.Version = img.Version
+			c.Protocol = img.Protocol
+			if _, err := img.NegotiateProtocol(); err != nil {
+				log.Printf("%v: %s (%s)", err, img.Language, img.Version)
+				c.Error = err.Error()
 			} else {
 				log.Printf("Loaded: %s (%s)", img.Language, img.Version)
+				if w := img.protocolWarning(); len(w) > 0 {
+					log.Printf("%s: %s (%s)", w, img.Language, img.Version)
+					img.Warnings = append(img.Warnings, w)
+					c.Warning = w
+				}
 				l[img.ID] = img
+				c.Loaded = true
 			}
 		}
+		candidates = append(candidates, c)
 	}
 
-	return l, nil
+	return l, candidates, nil
 }
 
 type ImageArray []Image
@@ -0,0 +1,139 @@
+package sango
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const defaultTolerance = 1e-6
+
+const (
+	VerdictAccepted            = "AC"
+	VerdictWrongAnswer         = "WA"
+	VerdictTimeLimitExceeded   = "TLE"
+	VerdictRuntimeError        = "RE"
+	VerdictMemoryLimitExceeded = "MLE"
+)
+
+var verdictStatus = map[string]string{
+	VerdictAccepted:            "Accepted",
+	VerdictWrongAnswer:         "Wrong answer",
+	VerdictTimeLimitExceeded:   "Time limit exceeded",
+	VerdictRuntimeError:        "Runtime error",
+	VerdictMemoryLimitExceeded: "Memory limit exceeded",
+}
+
+type TestCase struct {
+	Stdin     string  `json:"stdin"`
+	Expected  string  `json:"expected"`
+	Compare   string  `json:"compare,omitempty"`
+	Tolerance float64 `json:"tolerance,omitempty"`
+}
+
+type CaseResult struct {
+	Verdict string     `json:"verdict"`
+	Result  ExecResult `json:"result"`
+}
+
+// Match reports whether stdout is an acceptable answer. Compare is one of
+// "exact" (the default), "trim" which ignores trailing whitespace on every
+// line, and "float" which compares numeric tokens within Tolerance. NaN and
+// infinities only match themselves.
+func (t TestCase) Match(stdout string) bool {
+	switch t.Compare {
+	case "trim":
+		return trimLines(stdout) == trimLines(t.Expected)
+	case "float":
+		return matchFloat(stdout, t.Expected, t.Tolerance)
+	default:
+		return stdout == t.Expected
+	}
+}
+
+func trimLines(s string) string {
+	l := strings.Split(s, "\n")
+	for i := range l {
+		l[i] = strings.TrimRight(l[i], " \t\r")
+	}
+	return strings.TrimRight(strings.Join(l, "\n"), "\n")
+}
+
+func matchFloat(s, expected string, tolerance float64) bool {
+	if tolerance <= 0 {
+		tolerance = defaultTolerance
+	}
+	a := strings.Fields(s)
+	b := strings.Fields(expected)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		x, err := strconv.ParseFloat(a[i], 64)
+		if err != nil {
+			return false
+		}
+		y, err := strconv.ParseFloat(b[i], 64)
+		if err != nil {
+			return false
+		}
+		if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+			if x != y && !(math.IsNaN(x) && math.IsNaN(y)) {
+				return false
+			}
+			continue
+		}
+		d := math.Abs(x - y)
+		if d > tolerance && d > tolerance*math.Abs(y) {
+			return false
+		}
+	}
+	return true
+}
+
+// Judge gives the verdict of a single run of t. Runs killed for exceeding
+// the memory limit are runtime errors until markOOM is called.
+func (t TestCase) Judge(r ExecResult, err error) string {
+	if _, ok := err.(TimeoutError); ok {
+		return VerdictTimeLimitExceeded
+	}
+	if err != nil {
+		return VerdictRuntimeError
+	}
+	if !t.Match(r.Stdout) {
+		return VerdictWrongAnswer
+	}
+	return VerdictAccepted
+}
+
+// JudgeStatus returns the aggregate status of the cases, which is the
+// first verdict other than AC.
+func JudgeStatus(cases []CaseResult) string {
+	for _, c := range cases {
+		if c.Verdict != VerdictAccepted {
+			return verdictStatus[c.Verdict]
+		}
+	}
+	return verdictStatus[VerdictAccepted]
+}
+
+// markOOM gives MLE to the cases killed by SIGKILL once the container is
+// known to have run out of memory.
+func (o *Output) markOOM() {
+	oom := false
+	for i, c := range o.Cases {
+		if c.Verdict == VerdictRuntimeError && c.Result.Signal == int(syscall.SIGKILL) {
+			o.Cases[i].Verdict = VerdictMemoryLimitExceeded
+			oom = true
+		}
+	}
+	if oom {
+		o.Status = JudgeStatus(o.Cases)
+	} else {
+		o.Status = verdictStatus[VerdictMemoryLimitExceeded]
+	}
+}
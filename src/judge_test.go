@@ -0,0 +1,147 @@
+package sango
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+var matchTests = []struct {
+	compare   string
+	tolerance float64
+	expected  string
+	stdout    string
+	want      bool
+}{
+	{"", 0, "3\n", "3\n", true},
+	{"", 0, "3\n", "3", false},
+	{"", 0, "3\n", "3 \n", false},
+	{"exact", 0, "a b\n", "a b\n", true},
+	{"exact", 0, "a b\n", "a  b\n", false},
+
+	{"trim", 0, "a b\nc\n", "a b  \nc\t\n", true},
+	{"trim", 0, "a b\nc\n", "a b\nc", true},
+	{"trim", 0, "a b\nc\n", "a b\nc\n\n\n", true},
+	{"trim", 0, "a b\r\nc\r\n", "a b\nc\n", true},
+	{"trim", 0, "a b\nc\n", " a b\nc\n", false},
+	{"trim", 0, "a b\nc\n", "a  b\nc\n", false},
+	{"trim", 0, "a\n\nb\n", "a\nb\n", false},
+
+	{"float", 0, "0.5\n", "0.5000001\n", true},
+	{"float", 0, "0.5\n", "0.50001\n", false},
+	{"float", 1e-3, "0.5\n", "0.5004\n", true},
+	{"float", 1e-3, "0.5\n", "0.502\n", false},
+	// Large values are compared with a relative tolerance.
+	{"float", 0, "1000000000\n", "1000000000.5\n", true},
+	{"float", 0, "1 2 3\n", "1.0\n2.0 3.0", true},
+	{"float", 0, "Case 1: 0.25\n", "Case 1: 0.2500000001\n", true},
+	{"float", 0, "Case 1: 0.25\n", "case 1: 0.25\n", false},
+	{"float", 0, "1 2 3\n", "1 2\n", false},
+	{"float", 0, "1 2\n", "1 2 3\n", false},
+	{"float", 0, "1\n", "\n", false},
+	{"float", 0, "", "", true},
+	{"float", 0, "1\n", "abc\n", false},
+	{"float", 0, "NaN\n", "NaN\n", true},
+	{"float", 0, "nan\n", "NaN\n", true},
+	{"float", 0, "1\n", "NaN\n", false},
+	{"float", 0, "NaN\n", "1\n", false},
+	{"float", 0, "1\n", "Inf\n", false},
+	{"float", 0, "Inf\n", "1e308\n", false},
+	{"float", 0, "inf\n", "+Inf\n", true},
+	{"float", 0, "Inf\n", "-Inf\n", false},
+	{"float", 0, "NaN\n", "Inf\n", false},
+}
+
+func TestMatch(t *testing.T) {
+	for i, tt := range matchTests {
+		c := TestCase{Expected: tt.expected, Compare: tt.compare, Tolerance: tt.tolerance}
+		if got := c.Match(tt.stdout); got != tt.want {
+			t.Errorf("#%d %s: Match(%q) against %q = %v; want %v", i, tt.compare, tt.stdout, tt.expected, got, tt.want)
+		}
+	}
+}
+
+func TestJudge(t *testing.T) {
+	c := TestCase{Expected: "ok\n"}
+	tests := []struct {
+		r    ExecResult
+		err  error
+		want string
+	}{
+		{ExecResult{Stdout: "ok\n"}, nil, VerdictAccepted},
+		{ExecResult{Stdout: "ng\n"}, nil, VerdictWrongAnswer},
+		{ExecResult{Stdout: "ok\n"}, TimeoutError{}, VerdictTimeLimitExceeded},
+		{ExecResult{Stdout: "ok\n", Code: 1}, errors.New("exit status 1"), VerdictRuntimeError},
+		{ExecResult{Signal: int(syscall.SIGKILL)}, errors.New("signal: killed"), VerdictRuntimeError},
+	}
+	for i, tt := range tests {
+		if v := c.Judge(tt.r, tt.err); v != tt.want {
+			t.Errorf("#%d: Judge = %s; want %s", i, v, tt.want)
+		}
+	}
+}
+
+func cases(verdicts ...string) []CaseResult {
+	var l []CaseResult
+	for _, v := range verdicts {
+		l = append(l, CaseResult{Verdict: v})
+	}
+	return l
+}
+
+func TestJudgeStatus(t *testing.T) {
+	tests := []struct {
+		cases []CaseResult
+		want  string
+	}{
+		{nil, "Accepted"},
+		{cases("AC", "AC"), "Accepted"},
+		{cases("AC", "WA", "TLE"), "Wrong answer"},
+		{cases("TLE", "WA"), "Time limit exceeded"},
+		{cases("AC", "RE"), "Runtime error"},
+		{cases("MLE"), "Memory limit exceeded"},
+	}
+	for i, tt := range tests {
+		if s := JudgeStatus(tt.cases); s != tt.want {
+			t.Errorf("#%d: JudgeStatus = %s; want %s", i, s, tt.want)
+		}
+	}
+}
+
+func TestMarkOOM(t *testing.T) {
+	killed := ExecResult{Signal: int(syscall.SIGKILL)}
+	o := Output{
+		Status: "Runtime error",
+		Cases: []CaseResult{
+			{Verdict: VerdictAccepted},
+			{Verdict: VerdictRuntimeError, Result: ExecResult{Code: 1}},
+			{Verdict: VerdictRuntimeError, Result: killed},
+		},
+	}
+	o.markOOM()
+	if o.Cases[1].Verdict != VerdictRuntimeError || o.Cases[2].Verdict != VerdictMemoryLimitExceeded {
+		t.Errorf("verdicts = %s, %s", o.Cases[1].Verdict, o.Cases[2].Verdict)
+	}
+	if o.Status != "Runtime error" {
+		t.Errorf("Status = %s; want the first failing case", o.Status)
+	}
+
+	o = Output{
+		Status: "Runtime error",
+		Cases: []CaseResult{
+			{Verdict: VerdictAccepted},
+			{Verdict: VerdictRuntimeError, Result: killed},
+		},
+	}
+	o.markOOM()
+	if o.Status != "Memory limit exceeded" {
+		t.Errorf("Status = %s; want Memory limit exceeded", o.Status)
+	}
+
+	// Without test cases the whole run ran out of memory.
+	o = Output{Status: "Runtime error"}
+	o.markOOM()
+	if o.Status != "Memory limit exceeded" {
+		t.Errorf("Status = %s; want Memory limit exceeded", o.Status)
+	}
+}
@@ -0,0 +1,162 @@
+package sango
+
+import (
+	"strconv"
+	"time"
+)
+
+// containerGracePeriod is added to the time limits of a run so that the
+// agent can report a timeout before the container itself is killed.
+const containerGracePeriod = time.Second * 3
+
+type Limits struct {
+	Memory    int64   `yaml:"memory"     json:"memory,omitempty"`
+	CPUs      float64 `yaml:"cpus"       json:"cpus,omitempty"`
+	Pids      int64   `yaml:"pids"       json:"pids,omitempty"`
+	Disk      int64   `yaml:"disk"       json:"disk,omitempty"`
+	BuildTime float64 `yaml:"build_time" json:"build-time,omitempty"`
+	RunTime   float64 `yaml:"run_time"   json:"run-time,omitempty"`
+	// InteractiveTime replaces RunTime for interactive runs, which wait
+	// for a person to type and hold a scheduler slot meanwhile.
+	InteractiveTime float64 `yaml:"interactive_time" json:"interactive-time,omitempty"`
+	// Time is the time in seconds the whole pipeline may take. It is set
+	// by Exec and enforced by the agent.
+	Time float64 `yaml:"-" json:"-"`
+}
+
+// Merge returns l with every limit that is set in o replaced.
+func (l Limits) Merge(o Limits) Limits {
+	if o.Memory > 0 {
+		l.Memory = o.Memory
+	}
+	if o.CPUs > 0 {
+		l.CPUs = o.CPUs
+	}
+	if o.Pids > 0 {
+		l.Pids = o.Pids
+	}
+	if o.Disk > 0 {
+		l.Disk = o.Disk
+	}
+	if o.BuildTime > 0 {
+		l.BuildTime = o.BuildTime
+	}
+	if o.RunTime > 0 {
+		l.RunTime = o.RunTime
+	}
+	if o.InteractiveTime > 0 {
+		l.InteractiveTime = o.InteractiveTime
+	}
+	return l
+}
+
+// Narrow returns l with every limit that is set in o applied, as long as it
+// is stricter than the current one.
+func (l Limits) Narrow(o Limits) Limits {
+	if o.Memory > 0 && (l.Memory <= 0 || o.Memory < l.Memory) {
+		l.Memory = o.Memory
+	}
+	if o.CPUs > 0 && (l.CPUs <= 0 || o.CPUs < l.CPUs) {
+		l.CPUs = o.CPUs
+	}
+	if o.Pids > 0 && (l.Pids <= 0 || o.Pids < l.Pids) {
+		l.Pids = o.Pids
+	}
+	if o.Disk > 0 && (l.Disk <= 0 || o.Disk < l.Disk) {
+		l.Disk = o.Disk
+	}
+	if o.BuildTime > 0 && (l.BuildTime <= 0 || o.BuildTime < l.BuildTime) {
+		l.BuildTime = o.BuildTime
+	}
+	if o.RunTime > 0 && (l.RunTime <= 0 || o.RunTime < l.RunTime) {
+		l.RunTime = o.RunTime
+	}
+	if o.InteractiveTime > 0 && (l.InteractiveTime <= 0 || o.InteractiveTime < l.InteractiveTime) {
+		l.InteractiveTime = o.InteractiveTime
+	}
+	return l
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// defaultTimeLimit is the jtime default in seconds, used for the phases
+// without a time limit.
+const defaultTimeLimit = 5
+
+// interactive returns l for an interactive run, whose run phase gets
+// InteractiveTime instead of RunTime. The agent, the pipeline time and the
+// container timeout then all follow it.
+func (l Limits) interactive() Limits {
+	if l.InteractiveTime > 0 {
+		l.RunTime = l.InteractiveTime
+	}
+	return l
+}
+
+// customTime reports whether l sets a time limit other than the jtime
+// default, which agents older than protocol version 6 always apply.
+func (l Limits) customTime() bool {
+	return (l.BuildTime > 0 && l.BuildTime != defaultTimeLimit) ||
+		(l.RunTime > 0 && l.RunTime != defaultTimeLimit)
+}
+
+// timeout returns the time limit for the given phase, or zero if the
+// jtime default should be used. The run phase gets RunTime and every other
+// phase, such as build stages and actions, gets BuildTime.
+func (l Limits) timeout(phase string) time.Duration {
+	if phase == "run" {
+		return seconds(l.RunTime)
+	}
+	return seconds(l.BuildTime)
+}
+
+// pipelineTime returns the time in seconds the stages may take when the
+// program runs the given number of times. If stages is empty, a build and a
+// run stage are assumed.
+func (l Limits) pipelineTime(stages []Stage, runs int) float64 {
+	if runs < 1 {
+		runs = 1
+	}
+	if len(stages) == 0 {
+		stages = []Stage{{Name: "build"}, {Name: "run"}}
+	}
+	var t float64
+	for _, s := range stages {
+		d := s.timeout(l).Seconds()
+		if d <= 0 {
+			d = defaultTimeLimit
+		}
+		if s.Name == "run" {
+			d *= float64(runs)
+		}
+		t += d
+	}
+	return t
+}
+
+// containerTimeout returns how long a container whose pipeline may take
+// l.Time may live.
+func (l Limits) containerTimeout() time.Duration {
+	return seconds(l.Time) + containerGracePeriod
+}
+
+const cpuPeriod = 100000
+
+func (l Limits) apply(h *HostConfig) {
+	if l.Memory > 0 {
+		h.Memory = l.Memory
+		h.MemorySwap = l.Memory
+	}
+	if l.CPUs > 0 {
+		h.CpuPeriod = cpuPeriod
+		h.CpuQuota = int64(l.CPUs * cpuPeriod)
+	}
+	if l.Pids > 0 {
+		h.PidsLimit = l.Pids
+	}
+	if l.Disk > 0 {
+		h.StorageOpt = map[string]string{"size": strconv.FormatInt(l.Disk, 10)}
+	}
+}
@@ -0,0 +1,224 @@
+package sango
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Option is an option of an image. Type is one of
+//
+//	bool    true or false
+//	list    one of Candidates
+//	multi   a subset of Candidates
+//	int     an integer within Min and Max
+//	string  a string matching Pattern, up to MaxLength bytes
+//	flags   space-separated tokens, each matching Pattern
+type Option struct {
+	Title      string        `yaml:"title"      json:"title"`
+	Type       string        `yaml:"type"       json:"type"`
+	Default    interface{}   `yaml:"default"    json:"default"`
+	Candidates []interface{} `yaml:"candidates" json:"candidates,omitempty"`
+	Min        *int64        `yaml:"min"        json:"min,omitempty"`
+	Max        *int64        `yaml:"max"        json:"max,omitempty"`
+	Pattern    string        `yaml:"pattern"    json:"pattern,omitempty"`
+	MaxLength  int           `yaml:"max_length" json:"max-length,omitempty"`
+
+	re *regexp.Regexp
+}
+
+type OptionError struct {
+	Name    string
+	Message string
+}
+
+func (e OptionError) Error() string {
+	return fmt.Sprintf("Invalid option %q: %s", e.Name, e.Message)
+}
+
+// Normalize validates v and converts it to the form passed to the agent.
+// A nil v is replaced by the default value. Flags are split into a list of
+// tokens.
+func (o Option) Normalize(v interface{}) (interface{}, error) {
+	if v == nil {
+		v = o.Default
+	}
+	if v == nil {
+		switch o.Type {
+		case "multi":
+			v = []interface{}{}
+		case "string", "flags":
+			v = ""
+		}
+	}
+	switch o.Type {
+	case "bool":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("must be a boolean")
+	case "list":
+		if s, ok := v.(string); ok && o.candidate(s) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("must be one of %v", o.Candidates)
+	case "multi":
+		l, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("must be a list")
+		}
+		m := make(map[string]bool)
+		for _, i := range l {
+			s, ok := i.(string)
+			if !ok || !o.candidate(s) {
+				return nil, fmt.Errorf("%v is not one of %v", i, o.Candidates)
+			}
+			m[s] = true
+		}
+		var r []interface{}
+		for _, c := range o.Candidates {
+			if s, ok := c.(string); ok && m[s] {
+				r = append(r, s)
+			}
+		}
+		return r, nil
+	case "int":
+		n, ok := toInt(v)
+		if !ok {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		if o.Min != nil && n < *o.Min {
+			return nil, fmt.Errorf("must be at least %d", *o.Min)
+		}
+		if o.Max != nil && n > *o.Max {
+			return nil, fmt.Errorf("must be at most %d", *o.Max)
+		}
+		return n, nil
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string")
+		}
+		if o.MaxLength > 0 && len(s) > o.MaxLength {
+			return nil, fmt.Errorf("must be at most %d bytes", o.MaxLength)
+		}
+		if !o.match(s) {
+			return nil, fmt.Errorf("must match %s", o.Pattern)
+		}
+		return s, nil
+	case "flags":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string")
+		}
+		if o.MaxLength > 0 && len(s) > o.MaxLength {
+			return nil, fmt.Errorf("must be at most %d bytes", o.MaxLength)
+		}
+		var r []interface{}
+		for _, f := range strings.Fields(s) {
+			if !o.match(f) {
+				return nil, fmt.Errorf("%s is not allowed", f)
+			}
+			r = append(r, f)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown type %s", o.Type)
+}
+
+func (o Option) candidate(s string) bool {
+	for _, c := range o.Candidates {
+		if c == s {
+			return true
+		}
+	}
+	return false
+}
+
+// compile compiles Pattern so that match doesn't have to.
+func (o *Option) compile() error {
+	if len(o.Pattern) == 0 {
+		return nil
+	}
+	r, err := regexp.Compile("^(?:" + o.Pattern + ")$")
+	if err != nil {
+		return err
+	}
+	o.re = r
+	return nil
+}
+
+// match reports whether the whole s matches Pattern. An empty Pattern
+// matches everything.
+func (o Option) match(s string) bool {
+	if len(o.Pattern) == 0 {
+		return true
+	}
+	if o.re == nil && o.compile() != nil {
+		return false
+	}
+	return o.re.MatchString(s)
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case float64:
+		if n == float64(int64(n)) {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// CompileOptions compiles the patterns of the options. It must be called
+// once the image config is loaded. The error is an OptionError naming the
+// first option with an invalid pattern.
+func (i *Image) CompileOptions() error {
+	for k, o := range i.Options {
+		if err := o.compile(); err != nil {
+			return OptionError{Name: k, Message: err.Error()}
+		}
+		i.Options[k] = o
+	}
+	return nil
+}
+
+// NormalizeOptions returns options with every option of the image
+// validated and set to its default if missing. Options the image doesn't
+// declare are dropped. The error is an OptionError naming the first
+// invalid option.
+func (i Image) NormalizeOptions(options map[string]interface{}) (map[string]interface{}, error) {
+	r := make(map[string]interface{})
+	keys := make([]string, 0, len(i.Options))
+	for k := range i.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := i.Options[k].Normalize(options[k])
+		if err != nil {
+			return nil, OptionError{Name: k, Message: err.Error()}
+		}
+		r[k] = v
+	}
+	return r, nil
+}
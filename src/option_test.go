@@ -0,0 +1,134 @@
+package sango
+
+import (
+	"reflect"
+	"testing"
+)
+
+func int64p(n int64) *int64 {
+	return &n
+}
+
+var normalizeTests = []struct {
+	option Option
+	value  interface{}
+	want   interface{}
+	ok     bool
+}{
+	{Option{Type: "bool"}, true, true, true},
+	{Option{Type: "bool", Default: true}, nil, true, true},
+	{Option{Type: "bool"}, "true", nil, false},
+	{Option{Type: "bool"}, nil, nil, false},
+
+	{Option{Type: "list", Candidates: []interface{}{"c99", "c11"}}, "c11", "c11", true},
+	{Option{Type: "list", Candidates: []interface{}{"c99", "c11"}, Default: "c99"}, nil, "c99", true},
+	{Option{Type: "list", Candidates: []interface{}{"c99", "c11"}}, "c89", nil, false},
+	{Option{Type: "list", Candidates: []interface{}{"c99", "c11"}}, 99, nil, false},
+
+	// Multi keeps the order of the candidates and drops duplicates.
+	{Option{Type: "multi", Candidates: []interface{}{"a", "b", "c"}}, []interface{}{"c", "a", "c"}, []interface{}{"a", "c"}, true},
+	{Option{Type: "multi", Candidates: []interface{}{"a", "b"}}, []interface{}{}, []interface{}(nil), true},
+	{Option{Type: "multi", Candidates: []interface{}{"a", "b"}}, nil, []interface{}(nil), true},
+	{Option{Type: "multi", Candidates: []interface{}{"a", "b"}}, []interface{}{"a", "d"}, nil, false},
+	{Option{Type: "multi", Candidates: []interface{}{"a", "b"}}, []interface{}{1}, nil, false},
+	{Option{Type: "multi", Candidates: []interface{}{"a", "b"}}, "a", nil, false},
+
+	{Option{Type: "int", Min: int64p(1), Max: int64p(3)}, 1, int64(1), true},
+	{Option{Type: "int", Min: int64p(1), Max: int64p(3)}, int64(3), int64(3), true},
+	{Option{Type: "int", Min: int64p(1), Max: int64p(3)}, float64(2), int64(2), true},
+	{Option{Type: "int", Min: int64p(1), Max: int64p(3)}, uint8(2), int64(2), true},
+	{Option{Type: "int", Min: int64p(1), Max: int64p(3), Default: 2}, nil, int64(2), true},
+	{Option{Type: "int", Min: int64p(1), Max: int64p(3)}, 0, nil, false},
+	{Option{Type: "int", Min: int64p(1), Max: int64p(3)}, 4, nil, false},
+	{Option{Type: "int", Min: int64p(1), Max: int64p(3)}, 2.5, nil, false},
+	{Option{Type: "int", Min: int64p(1), Max: int64p(3)}, "2", nil, false},
+	{Option{Type: "int"}, -1000000, int64(-1000000), true},
+
+	{Option{Type: "string", Pattern: "[a-z]+"}, "abc", "abc", true},
+	{Option{Type: "string", Pattern: "[a-z]+"}, "abc1", nil, false},
+	// The pattern must match the whole value.
+	{Option{Type: "string", Pattern: "[a-z]+"}, "1abc", nil, false},
+	{Option{Type: "string", Pattern: "a|b"}, "ab", nil, false},
+	{Option{Type: "string", Pattern: "a|b"}, "b", "b", true},
+	{Option{Type: "string", MaxLength: 3}, "abc", "abc", true},
+	{Option{Type: "string", MaxLength: 3}, "abcd", nil, false},
+	{Option{Type: "string"}, nil, "", true},
+	{Option{Type: "string"}, 1, nil, false},
+
+	{Option{Type: "flags", Pattern: "-[OW][a-z0-9-]*"}, "-O2  -Wall", []interface{}{"-O2", "-Wall"}, true},
+	{Option{Type: "flags", Pattern: "-[OW][a-z0-9-]*"}, "", []interface{}(nil), true},
+	{Option{Type: "flags", Pattern: "-[OW][a-z0-9-]*"}, "-O2 -fplugin=evil.so", nil, false},
+	{Option{Type: "flags", Pattern: "-[OW][a-z0-9-]*"}, "-O2;rm", nil, false},
+	{Option{Type: "flags", MaxLength: 5}, "-O2 -Wall", nil, false},
+	{Option{Type: "flags"}, []interface{}{"-O2"}, nil, false},
+
+	{Option{Type: "color"}, "red", nil, false},
+}
+
+func TestNormalize(t *testing.T) {
+	for i, tt := range normalizeTests {
+		o := tt.option
+		if err := o.compile(); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		v, err := o.Normalize(tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("#%d %s: Normalize(%#v) error = %v; want ok = %v", i, o.Type, tt.value, err, tt.ok)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("#%d %s: Normalize(%#v) = %#v; want %#v", i, o.Type, tt.value, v, tt.want)
+		}
+	}
+}
+
+func TestNormalizeOptions(t *testing.T) {
+	img := Image{Options: map[string]Option{
+		"std":   {Type: "list", Candidates: []interface{}{"c99", "c11"}, Default: "c11"},
+		"flags": {Type: "flags", Pattern: "-O[0-3]"},
+		"race":  {Type: "bool", Default: false},
+	}}
+	if err := img.CompileOptions(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := img.NormalizeOptions(map[string]interface{}{
+		"flags":   "-O2",
+		"unknown": "-fplugin=evil.so",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"std":   "c11",
+		"flags": []interface{}{"-O2"},
+		"race":  false,
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("NormalizeOptions = %#v; want %#v", r, want)
+	}
+
+	_, err = img.NormalizeOptions(map[string]interface{}{"flags": "-O9", "std": "c89"})
+	if e, ok := err.(OptionError); !ok || e.Name != "flags" {
+		t.Errorf("err = %#v; want an OptionError for flags", err)
+	}
+}
+
+func TestCompileOptions(t *testing.T) {
+	img := Image{Options: map[string]Option{
+		"ok":  {Type: "string", Pattern: "[a-z]+"},
+		"bad": {Type: "string", Pattern: "("},
+	}}
+	err := img.CompileOptions()
+	if e, ok := err.(OptionError); !ok || e.Name != "bad" {
+		t.Errorf("err = %#v; want an OptionError for bad", err)
+	}
+
+	img = Image{Options: map[string]Option{"ok": {Type: "string", Pattern: "[a-z]+"}}}
+	if err := img.CompileOptions(); err != nil {
+		t.Fatal(err)
+	}
+	if img.Options["ok"].re == nil {
+		t.Error("pattern not compiled")
+	}
+}
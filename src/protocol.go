@@ -0,0 +1,199 @@
+package sango
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// MinProtocolVersion is the oldest protocol version the server still
+// speaks. Images that only speak an older version are rejected.
+const MinProtocolVersion = 5
+
+// protocolAdapter converts the input and output of the current protocol
+// for an image that speaks an older one.
+type protocolAdapter struct {
+	encodeInput  func(in Input) ([]byte, error)
+	decodeOutput func(data []byte, out *Output) error
+}
+
+var protocolAdapters = map[int]protocolAdapter{
+	5: {encodeInput: encodeInputV5, decodeOutput: decodeOutputV5},
+	6: {encodeInput: encodeInputV6, decodeOutput: decodeOutputV6},
+}
+
+// inputV5 is Input as of protocol version 5.
+type inputV5 struct {
+	Files   map[string]string
+	Stdin   string
+	Options map[string]interface{}
+}
+
+func encodeInputV5(in Input) ([]byte, error) {
+	return msgpack.Marshal(inputV5{
+		Files:   in.Files,
+		Stdin:   in.Stdin,
+		Options: in.Options,
+	})
+}
+
+// outputV5 is Output as of protocol version 5.
+type outputV5 struct {
+	Results     map[string]ExecResult
+	MixedOutput []Message
+	Status      string
+}
+
+func decodeOutputV5(data []byte, out *Output) error {
+	var o outputV5
+	err := msgpack.Unmarshal(data, &o)
+	if err != nil {
+		return err
+	}
+	out.Results = o.Results
+	out.Status = o.Status
+	return nil
+}
+
+// inputV6 is Input as of protocol version 6.
+type inputV6 struct {
+	Files       map[string]string
+	BinaryFiles map[string][]byte
+	Artifacts   []string
+	Stdin       string
+	Options     map[string]interface{}
+	Tests       []TestCase
+	Interactive bool
+	Limits      Limits
+}
+
+func encodeInputV6(in Input) ([]byte, error) {
+	return msgpack.Marshal(inputV6{
+		Files:       in.Files,
+		BinaryFiles: in.BinaryFiles,
+		Artifacts:   in.Artifacts,
+		Stdin:       in.Stdin,
+		Options:     in.Options,
+		Tests:       in.Tests,
+		Interactive: in.Interactive,
+		Limits:      in.Limits,
+	})
+}
+
+// outputV6 is Output as of protocol version 6.
+type outputV6 struct {
+	Results     map[string]ExecResult
+	MixedOutput []Message
+	Status      string
+	Cases       []CaseResult
+	Artifacts   []Artifact
+}
+
+func decodeOutputV6(data []byte, out *Output) error {
+	var o outputV6
+	err := msgpack.Unmarshal(data, &o)
+	if err != nil {
+		return err
+	}
+	out.Results = o.Results
+	out.Status = o.Status
+	out.Cases = o.Cases
+	out.Artifacts = o.Artifacts
+	return nil
+}
+
+// decodeOutputCurrent decodes the output of the current protocol version.
+// Like the adapters, it keeps the MixedOutput collected from the stream and
+// the Limits of out.
+func decodeOutputCurrent(data []byte, out *Output) error {
+	var o Output
+	err := msgpack.Unmarshal(data, &o)
+	if err != nil {
+		return err
+	}
+	out.Results = o.Results
+	out.Status = o.Status
+	out.Stages = o.Stages
+	out.Cases = o.Cases
+	out.Artifacts = o.Artifacts
+	return nil
+}
+
+// protocolRange returns the protocol versions the image speaks. Images
+// older than version 6 only advertise a single version.
+func (i Image) protocolRange() (int, int) {
+	if i.ProtocolMin == 0 || i.ProtocolMin > i.Protocol {
+		return i.Protocol, i.Protocol
+	}
+	return i.ProtocolMin, i.Protocol
+}
+
+// NegotiateProtocol returns the newest protocol version spoken by both the
+// server and the image.
+func (i Image) NegotiateProtocol() (int, error) {
+	min, max := i.protocolRange()
+	v := max
+	if v > ProtocolVersion {
+		v = ProtocolVersion
+	}
+	if v < min || v < MinProtocolVersion {
+		return 0, fmt.Errorf("Protocol version mismatch: %d-%d (want %d-%d)", min, max, MinProtocolVersion, ProtocolVersion)
+	}
+	return v, nil
+}
+
+// protocolWarning returns a deprecation warning for images that don't speak
+// the current protocol version.
+func (i Image) protocolWarning() string {
+	v, err := i.NegotiateProtocol()
+	if err != nil || v == ProtocolVersion {
+		return ""
+	}
+	return fmt.Sprintf("Protocol version %d is deprecated; rebuild the image to use version %d", v, ProtocolVersion)
+}
+
+// CheckInput returns an error if in uses a feature that the protocol
+// spoken by the image doesn't support, so that it is never silently
+// dropped. in.Limits must be the limits of the run.
+func (i Image) CheckInput(in Input) error {
+	v, err := i.NegotiateProtocol()
+	if err != nil {
+		return err
+	}
+	switch {
+	case v < 6 && len(in.Tests) > 0:
+		return errors.New("Tests are not supported by this environment")
+	case v < 6 && in.Interactive:
+		return errors.New("Interactive input is not supported by this environment")
+	case v < 6 && len(in.BinaryFiles) > 0:
+		return errors.New("Binary files are not supported by this environment")
+	case v < 6 && len(in.Artifacts) > 0:
+		return errors.New("Artifacts are not supported by this environment")
+	case v < 6 && in.Limits.customTime():
+		return fmt.Errorf("Time limits other than %ds are not supported by this environment", defaultTimeLimit)
+	case v < 7 && len(in.Main) > 0:
+		return errors.New("Main file selection is not supported by this environment")
+	}
+	return nil
+}
+
+func (i Image) encodeInput(in Input) ([]byte, error) {
+	err := i.CheckInput(in)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := i.NegotiateProtocol()
+	if a, ok := protocolAdapters[v]; ok {
+		return a.encodeInput(in)
+	}
+	return msgpack.Marshal(in)
+}
+
+func (i Image) decodeOutput(data []byte, out *Output) error {
+	v, _ := i.NegotiateProtocol()
+	if a, ok := protocolAdapters[v]; ok {
+		return a.decodeOutput(data, out)
+	}
+	return decodeOutputCurrent(data, out)
+}
@@ -9,13 +9,23 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/vmihailenco/msgpack"
 	"gopkg.in/yaml.v2"
 )
 
-const ProtocolVersion = 5
+// ProtocolVersion is the version of the Input and Output wire format.
+// Version 6 added time limits, test cases, interactive stdin, binary files
+// and artifacts, and version 7 added the main file, stages and diagnostics.
+// Every change to the format must bump it, with an adapter in protocol.go
+// and a check in CheckInput for the features older images can't handle.
+const ProtocolVersion = 7
 
 type AgentBase struct {
 }
@@ -41,39 +51,197 @@ type Agent interface {
 	Test() (map[string]string, string, string)
 }
 
+// Stage is a step of the run pipeline. The stage called "run" runs the
+// program with the stdin or the tests of the input.
+type Stage struct {
+	Name    string
+	Command []string
+	// Continue runs the following stages even if this one fails.
+	Continue bool
+	// Timeout in seconds. If zero, the run time limit is used for the run
+	// stage and the build time limit for the others.
+	Timeout float64
+	// Status reported if the stage fails. If empty, it is "Runtime error"
+	// for the run stage and "Build error" for the others.
+	Status string
+	// Diagnostics is the format of the diagnostics in the output of the
+	// command. See ParseDiagnostics.
+	Diagnostics string
+}
+
+// StageAgent is implemented by agents that run more stages than build and
+// run.
+type StageAgent interface {
+	Stages(in Input) ([]Stage, error)
+}
+
+func (s Stage) timeout(l Limits) time.Duration {
+	if s.Timeout > 0 {
+		return seconds(s.Timeout)
+	}
+	return l.timeout(s.Name)
+}
+
+func (s Stage) failure(err error) string {
+	if _, ok := err.(TimeoutError); ok {
+		return "Time limit exceeded"
+	} else if len(s.Status) > 0 {
+		return s.Status
+	} else if s.Name == "run" {
+		return "Runtime error"
+	}
+	return "Build error"
+}
+
+// AgentStages returns the stages of act. Agents that don't implement
+// StageAgent have a build stage, if BuildCommand succeeds, and a run stage.
+func AgentStages(act Agent, in Input) ([]Stage, error) {
+	if a, ok := act.(StageAgent); ok {
+		return a.Stages(in)
+	}
+	return defaultStages(act, in)
+}
+
+// defaultStages returns the build and run stages of act. The diagnostics
+// of a DiagnosticAgent are parsed from the build, or from the run if there
+// is no build.
+func defaultStages(act Agent, in Input) ([]Stage, error) {
+	var format string
+	if d, ok := act.(DiagnosticAgent); ok {
+		format = d.DiagnosticFormat()
+	}
+
+	var stages []Stage
+	a, err := act.BuildCommand(in)
+	if err == nil {
+		stages = append(stages, Stage{Name: "build", Command: a, Diagnostics: format})
+		format = ""
+	}
+	a, err = act.RunCommand(in)
+	if err != nil {
+		return nil, err
+	}
+	return append(stages, Stage{Name: "run", Command: a, Diagnostics: format}), nil
+}
+
+func (s Stage) diagnostics(r ExecResult) []Diagnostic {
+	return parseDiagnostics(s.Diagnostics, r)
+}
+
+// MapToFileList returns the names of the files in sorted order.
 func MapToFileList(files map[string]string) []string {
 	l := make([]string, 0, len(files))
 	for k := range files {
 		l = append(l, k)
 	}
+	sort.Strings(l)
+	return l
+}
+
+// SourceFiles returns the sorted names of the files with one of the
+// extensions. The other files, such as headers, are only written to the
+// working directory.
+func SourceFiles(in Input, ext ...string) []string {
+	var l []string
+	for _, f := range MapToFileList(in.Files) {
+		if matchExt(f, ext) {
+			l = append(l, f)
+		}
+	}
 	return l
 }
 
+// MainFile returns the file to execute, which is in.Main if it has one of
+// the extensions and otherwise the first source file.
+func MainFile(in Input, ext ...string) string {
+	if len(in.Main) > 0 && matchExt(in.Main, ext) {
+		return in.Main
+	}
+	if l := SourceFiles(in, ext...); len(l) > 0 {
+		return l[0]
+	}
+	return ""
+}
+
+// ImageConfig is the path of the config.yml of the image inside it.
+const ImageConfig = "/tmp/sango/config.yml"
+
+func loadImageConfig(path string) (Image, error) {
+	var img Image
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return img, err
+	}
+	err = yaml.Unmarshal(data, &img)
+	if err != nil {
+		return img, err
+	}
+	return img, img.CompileOptions()
+}
+
+var imageExtensions struct {
+	sync.Once
+	l []string
+}
+
+// Extensions returns the source file extensions declared in the config.yml
+// of the image the agent runs in, followed by extra.
+func Extensions(extra ...string) []string {
+	imageExtensions.Do(func() {
+		img, err := loadImageConfig(ImageConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		imageExtensions.l = img.Extensions
+	})
+	return append(append([]string(nil), imageExtensions.l...), extra...)
+}
+
+// IsSource reports whether name has one of the extensions of the image.
+func (i Image) IsSource(name string) bool {
+	return matchExt(name, i.Extensions)
+}
+
+func matchExt(name string, ext []string) bool {
+	if len(ext) == 0 {
+		return true
+	}
+	e := strings.TrimPrefix(filepath.Ext(name), ".")
+	for _, x := range ext {
+		if e == x {
+			return true
+		}
+	}
+	return false
+}
+
 func Run(act Agent) {
 	flag.Parse()
 	subcommand := flag.Arg(0)
 
 	switch subcommand {
 	case "version":
-		var img Image
-		data, err := ioutil.ReadFile("/tmp/sango/config.yml")
+		img, err := loadImageConfig(ImageConfig)
 		if err != nil {
 			return
 		}
 
-		err = yaml.Unmarshal(data, &img)
-		if err != nil {
-			return
-		}
-
-		data, _ = ioutil.ReadFile("/tmp/sango/template.txt")
+		data, _ := ioutil.ReadFile("/tmp/sango/template.txt")
 		img.Template = string(data)
 
 		ver := strings.Trim(act.Version(), "\r\n ")
 		img.Version = ver
 		img.Protocol = ProtocolVersion
+		img.ProtocolMin = MinProtocolVersion
 		img.Actions = []string{"run"}
 
+		stages, err := AgentStages(act, Input{})
+		if err == nil {
+			for _, s := range stages {
+				img.Stages = append(img.Stages, Stage{Name: s.Name, Timeout: s.Timeout})
+			}
+		}
+
 		c, err := act.ActionCommands(Input{})
 		if err == nil {
 			for k := range c {
@@ -89,23 +257,18 @@ func Run(act Agent) {
 		files, stdin, stdout := act.Test()
 		in := Input{Stdin: stdin, Files: files}
 
-		a, err := act.BuildCommand(in)
-		if err == nil {
-			_, err := Jtime(a, "build", in, nil)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
-		a, err = act.RunCommand(in)
-		if err != nil {
-			log.Fatal(err)
-		}
-		r, err := Jtime(a, "run", in, nil)
+		stages, err := AgentStages(act, in)
 		if err != nil {
 			log.Fatal(err)
 		}
-		if r.Stdout != stdout {
-			log.Fatalf("stdout should be %s; got %s", stdout, r.Stdout)
+		for _, s := range stages {
+			r, err := jtime(s.Command, s.Name, strings.NewReader(in.Stdin), s.timeout(in.Limits), nil)
+			if err != nil && !s.Continue {
+				log.Fatal(err)
+			}
+			if s.Name == "run" && r.Stdout != stdout {
+				log.Fatalf("stdout should be %s; got %s", stdout, r.Stdout)
+			}
 		}
 
 	case "cmd":
@@ -116,19 +279,15 @@ func Run(act Agent) {
 			return
 		}
 
-		for k, v := range in.Files {
-			ioutil.WriteFile(k, []byte(v), 0644)
-		}
+		writeInput(in)
 
 		var command = map[string]string{}
 
-		a, err := act.BuildCommand(in)
-		if err == nil {
-			command["build"] = strings.Join(a, " ")
-		}
-		a, err = act.RunCommand(in)
+		stages, err := AgentStages(act, in)
 		if err == nil {
-			command["run"] = strings.Join(a, " ")
+			for _, s := range stages {
+				command[s.Name] = strings.Join(s.Command, " ")
+			}
 		}
 
 		c, err := act.ActionCommands(in)
@@ -150,44 +309,33 @@ func Run(act Agent) {
 			return
 		}
 
-		for k, v := range in.Files {
-			ioutil.WriteFile(k, []byte(v), 0644)
-		}
+		writeInput(in)
 
 		out := Output{Results: make(map[string]ExecResult)}
 		out.Status = "Success"
 
-		var builderr bool
-		a, err := act.BuildCommand(in)
-		if err == nil {
-			r, err := Jtime(a, "build", in, os.Stderr)
-			if err != nil {
-				builderr = true
-				if _, ok := err.(TimeoutError); ok {
-					out.Status = "Time limit exceeded"
-				} else {
-					out.Status = "Build error"
-				}
-			}
-			out.Results["build"] = r
+		var stdin io.Reader = strings.NewReader(in.Stdin)
+		if in.Interactive {
+			stdin = interactiveStdin(d)
 		}
 
-		if !builderr {
-			a, err = act.RunCommand(in)
-			if err != nil {
-				log.Fatal(err)
-			}
-			r, err := Jtime(a, "run", in, os.Stderr)
-			if err != nil {
-				if _, ok := err.(TimeoutError); ok {
-					out.Status = "Time limit exceeded"
-				} else {
-					out.Status = "Runtime error"
-				}
+		stages, err := AgentStages(act, in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var deadline time.Time
+		if in.Limits.Time > 0 {
+			deadline = time.Now().Add(seconds(in.Limits.Time))
+		}
+		for _, s := range stages {
+			out.Stages = append(out.Stages, s.Name)
+			if !runStage(s, in, stdin, deadline, &out) && !s.Continue {
+				break
 			}
-			out.Results["run"] = r
 		}
 
+		out.Artifacts = collectArtifacts(in.Artifacts)
+
 		e := msgpack.NewEncoder(os.Stdout)
 		e.Encode(out)
 		os.Stdout.Close()
@@ -200,9 +348,7 @@ func Run(act Agent) {
 			return
 		}
 
-		for k, v := range in.Files {
-			ioutil.WriteFile(k, []byte(v), 0644)
-		}
+		writeInput(in)
 
 		out := Output{Results: make(map[string]ExecResult)}
 		out.Status = "Success"
@@ -218,6 +364,149 @@ func Run(act Agent) {
 	}
 }
 
+func writeInput(in Input) {
+	for k, v := range in.Files {
+		ioutil.WriteFile(k, []byte(v), 0644)
+	}
+	for k, v := range in.BinaryFiles {
+		ioutil.WriteFile(k, v, 0644)
+	}
+}
+
+// collectArtifacts reads the files in the working directory that match
+// the patterns. Files over the size limits are listed without their data.
+func collectArtifacts(patterns []string) []Artifact {
+	var l []Artifact
+	found := make(map[string]bool)
+	var total int64
+	for _, p := range patterns {
+		if filepath.IsAbs(p) || strings.Contains(p, "..") {
+			continue
+		}
+		m, _ := filepath.Glob(p)
+		for _, name := range m {
+			info, err := os.Stat(name)
+			if err != nil || !info.Mode().IsRegular() || found[name] {
+				continue
+			}
+			found[name] = true
+			a := Artifact{Name: name, Size: info.Size()}
+			if info.Size() > ArtifactSizeLimit || total+info.Size() > ArtifactTotalLimit {
+				a.Truncated = true
+			} else {
+				data, err := ioutil.ReadFile(name)
+				if err != nil {
+					continue
+				}
+				a.Data = data
+				total += a.Size
+			}
+			l = append(l, a)
+		}
+	}
+	return l
+}
+
+// interactiveStdin returns a reader that yields the data of the "stdin"
+// messages following the input until an "eof" message arrives.
+func interactiveStdin(d *msgpack.Decoder) io.Reader {
+	r, w := io.Pipe()
+	go func() {
+		for {
+			var m Message
+			err := d.Decode(&m)
+			if err != nil || m.Tag == "eof" {
+				w.Close()
+				return
+			}
+			if m.Tag == "stdin" {
+				_, err := w.Write([]byte(m.Data))
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return r
+}
+
+// runStage runs s and records its result in out. The status is set by the
+// first stage that fails. It returns false if s failed. The stage is cut
+// short at the deadline of the pipeline unless deadline is zero.
+func runStage(s Stage, in Input, stdin io.Reader, deadline time.Time, out *Output) bool {
+	fail := func(status string) {
+		if out.Status == "Success" {
+			out.Status = status
+		}
+	}
+	timeout := func() time.Duration {
+		t := s.timeout(in.Limits)
+		if t <= 0 {
+			t = defaultTimeLimit * time.Second
+		}
+		if !deadline.IsZero() {
+			if r := deadline.Sub(time.Now()); r < t {
+				t = r
+			}
+		}
+		return t
+	}
+	if timeout() <= 0 {
+		out.Results[s.Name] = ExecResult{Timeout: true}
+		fail(s.failure(TimeoutError{}))
+		return false
+	}
+
+	if s.Name != "run" {
+		sin := in.Stdin
+		if in.Interactive {
+			sin = ""
+		}
+		r, err := jtime(s.Command, s.Name, strings.NewReader(sin), timeout(), os.Stderr)
+		r.Diagnostics = s.diagnostics(r)
+		out.Results[s.Name] = r
+		if err != nil {
+			fail(s.failure(err))
+			return false
+		}
+		return true
+	}
+
+	if len(in.Tests) > 0 {
+		for i, t := range in.Tests {
+			var r ExecResult
+			var err error
+			if d := timeout(); d > 0 {
+				r, err = jtime(s.Command, "run"+strconv.Itoa(i), strings.NewReader(t.Stdin), d, os.Stderr)
+			} else {
+				r, err = ExecResult{Timeout: true}, TimeoutError{}
+			}
+			out.Cases = append(out.Cases, CaseResult{
+				Verdict: t.Judge(r, err),
+				Result:  r,
+			})
+		}
+		status := JudgeStatus(out.Cases)
+		if status != verdictStatus[VerdictAccepted] {
+			fail(status)
+			return false
+		}
+		if out.Status == "Success" {
+			out.Status = status
+		}
+		return true
+	}
+
+	r, err := jtime(s.Command, "run", stdin, timeout(), os.Stderr)
+	r.Diagnostics = s.diagnostics(r)
+	out.Results["run"] = r
+	if err != nil {
+		fail(s.failure(err))
+		return false
+	}
+	return true
+}
+
 func System(wdir, stdin, command string, args ...string) (string, string) {
 	path, _ := os.Getwd()
 	os.Chdir(wdir)
@@ -232,15 +521,39 @@ func System(wdir, stdin, command string, args ...string) (string, string) {
 }
 
 func Jtime(a []string, p string, in Input, msgout io.Writer) (ExecResult, error) {
+	return JtimeStdin(a, p, strings.NewReader(in.Stdin), in, msgout)
+}
+
+// JtimeStdin is like Jtime but feeds the command from stdin instead of
+// in.Stdin. The command may exit before stdin is drained.
+func JtimeStdin(a []string, p string, stdin io.Reader, in Input, msgout io.Writer) (ExecResult, error) {
+	return jtime(a, p, stdin, in.Limits.timeout(p), msgout)
+}
+
+func jtime(a []string, p string, stdin io.Reader, timeout time.Duration, msgout io.Writer) (ExecResult, error) {
 	var stdout bytes.Buffer
 	var result ExecResult
-	cmd := exec.Command("jtime", append([]string{"-p=" + p + "-", "--"}, a...)...)
-	cmd.Stdin = strings.NewReader(in.Stdin)
+	args := []string{"-p=" + p + "-"}
+	if timeout > 0 {
+		args = append(args, "-t="+timeout.String())
+	}
+	cmd := exec.Command("jtime", append(append(args, "--"), a...)...)
 	cmd.Stdout = &stdout
 	cmd.Stderr = msgout
-	cmd.Run()
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return ExecResult{}, err
+	}
+	err = cmd.Start()
+	if err == nil {
+		go func() {
+			io.Copy(w, stdin)
+			w.Close()
+		}()
+		cmd.Wait()
+	}
 
-	err := msgpack.Unmarshal(stdout.Bytes(), &result)
+	err = msgpack.Unmarshal(stdout.Bytes(), &result)
 	if err != nil {
 		return ExecResult{}, err
 	}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// LevelDB is an embedded Store for single-node installs. Every value is
+// prefixed with its expiry time, since LevelDB has no native TTL.
+type LevelDB struct {
+	db *leveldb.DB
+}
+
+func NewLevelDB(path string) (*LevelDB, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDB{db: db}, nil
+}
+
+func encodeValue(value []byte, ttl time.Duration) []byte {
+	data := make([]byte, 8+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	copy(data[8:], value)
+	return data
+}
+
+func decodeValue(data []byte) ([]byte, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	expire := int64(binary.BigEndian.Uint64(data))
+	if expire != 0 && time.Now().UnixNano() > expire {
+		return nil, false
+	}
+	return data[8:], true
+}
+
+func (l *LevelDB) Get(key string) ([]byte, error) {
+	data, err := l.db.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	value, ok := decodeValue(data)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (l *LevelDB) Set(key string, value []byte, ttl time.Duration) error {
+	return l.db.Put([]byte(key), encodeValue(value, ttl), nil)
+}
+
+func (l *LevelDB) Exists(key string) (bool, error) {
+	_, err := l.Get(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *LevelDB) Delete(key string) error {
+	return l.db.Delete([]byte(key), nil)
+}
+
+func (l *LevelDB) Sweep() (int, error) {
+	iter := l.db.NewIterator(nil, nil)
+	defer iter.Release()
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		if k := iter.Key(); len(k) > 0 && k[0] == 0 {
+			continue
+		}
+		if _, ok := decodeValue(iter.Value()); !ok {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+		}
+	}
+	err := iter.Error()
+	if err != nil {
+		return 0, err
+	}
+	return batch.Len(), l.db.Write(batch, nil)
+}
+
+// Index entries are kept under "\x00i" + index + "\x00" + score + member
+// so that a range scan returns them in score order. The score of each
+// member is also stored under "\x00m" + index + "\x00" + member for
+// removal.
+
+func indexPrefix(index string) []byte {
+	return []byte("\x00i" + index + "\x00")
+}
+
+func indexKey(index string, score int64) []byte {
+	k := indexPrefix(index)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(score)^(1<<63))
+	return append(k, b[:]...)
+}
+
+func memberKey(index, member string) []byte {
+	return []byte("\x00m" + index + "\x00" + member)
+}
+
+func (l *LevelDB) IndexAdd(index, member string, score int64) error {
+	err := l.IndexRemove(index, member)
+	if err != nil {
+		return err
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(score))
+	batch := new(leveldb.Batch)
+	batch.Put(append(indexKey(index, score), member...), nil)
+	batch.Put(memberKey(index, member), b[:])
+	return l.db.Write(batch, nil)
+}
+
+func (l *LevelDB) IndexRemove(index, member string) error {
+	data, err := l.db.Get(memberKey(index, member), nil)
+	if err == leveldb.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	score := int64(binary.BigEndian.Uint64(data))
+	batch := new(leveldb.Batch)
+	batch.Delete(append(indexKey(index, score), member...))
+	batch.Delete(memberKey(index, member))
+	return l.db.Write(batch, nil)
+}
+
+func (l *LevelDB) IndexRange(index string, since, before int64, limit int) ([]string, error) {
+	r := util.BytesPrefix(indexPrefix(index))
+	if since != 0 {
+		r.Start = indexKey(index, since)
+	}
+	if before != 0 {
+		r.Limit = indexKey(index, before)
+	}
+	n := len(indexPrefix(index)) + 8
+
+	iter := l.db.NewIterator(r, nil)
+	defer iter.Release()
+	var members []string
+	for ok := iter.Last(); ok && len(members) < limit; ok = iter.Prev() {
+		members = append(members, string(iter.Key()[n:]))
+	}
+	return members, iter.Error()
+}
+
+func (l *LevelDB) Close() error {
+	return l.db.Close()
+}
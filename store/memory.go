@@ -0,0 +1,145 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	value  []byte
+	expire time.Time
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// Memory is a Store that keeps everything in memory. It is meant for
+// tests and throwaway instances.
+type Memory struct {
+	mutex   sync.Mutex
+	data    map[string]entry
+	indexes map[string]map[string]int64
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		data:    make(map[string]entry),
+		indexes: make(map[string]map[string]int64),
+	}
+}
+
+func (m *Memory) Get(key string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.data[key]
+	if !ok || e.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return e.value, nil
+}
+
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e := entry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		e.expire = time.Now().Add(ttl)
+	}
+	m.data[key] = e
+	return nil
+}
+
+func (m *Memory) Exists(key string) (bool, error) {
+	_, err := m.Get(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *Memory) Sweep() (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	n := 0
+	for k, e := range m.data {
+		if e.expired(now) {
+			delete(m.data, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) IndexAdd(index, member string, score int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.indexes[index] == nil {
+		m.indexes[index] = make(map[string]int64)
+	}
+	m.indexes[index][member] = score
+	return nil
+}
+
+func (m *Memory) IndexRemove(index, member string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.indexes[index], member)
+	return nil
+}
+
+type scored struct {
+	member string
+	score  int64
+}
+
+type byScore []scored
+
+func (a byScore) Len() int {
+	return len(a)
+}
+
+func (a byScore) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+func (a byScore) Less(i, j int) bool {
+	if a[i].score != a[j].score {
+		return a[i].score > a[j].score
+	}
+	return a[i].member > a[j].member
+}
+
+func (m *Memory) IndexRange(index string, since, before int64, limit int) ([]string, error) {
+	m.mutex.Lock()
+	var l byScore
+	for k, v := range m.indexes[index] {
+		if (since == 0 || v >= since) && (before == 0 || v < before) {
+			l = append(l, scored{k, v})
+		}
+	}
+	m.mutex.Unlock()
+
+	sort.Sort(l)
+	var r []string
+	for _, s := range l {
+		if len(r) >= limit {
+			break
+		}
+		r = append(r, s.member)
+	}
+	return r, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	redisMaxIdle        = 16
+	redisIdleTimeout    = time.Minute * 4
+	redisConnectTimeout = time.Second * 2
+	redisIOTimeout      = time.Second * 5
+	redisCheckInterval  = time.Minute
+)
+
+// Redis is a Store backed by a pool of Redis connections. Broken
+// connections are dropped from the pool and redialed on the next use.
+type Redis struct {
+	pool *redis.Pool
+}
+
+func NewRedis(addr string) (*Redis, error) {
+	pool := &redis.Pool{
+		MaxIdle:     redisMaxIdle,
+		IdleTimeout: redisIdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialTimeout("tcp", addr, redisConnectTimeout, redisIOTimeout, redisIOTimeout)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < redisCheckInterval {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	return &Redis{pool: pool}, nil
+}
+
+func (r *Redis) do(cmd string, args ...interface{}) (interface{}, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	reply, err := c.Do(cmd, args...)
+	if err != nil {
+		if _, ok := err.(redis.Error); !ok {
+			err = UnavailableError{err}
+		}
+	}
+	return reply, err
+}
+
+func (r *Redis) Get(key string) ([]byte, error) {
+	data, err := redis.Bytes(r.do("GET", key))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (r *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	var err error
+	if ttl > 0 {
+		_, err = r.do("SET", key, value, "PX", int64(ttl/time.Millisecond))
+	} else {
+		_, err = r.do("SET", key, value)
+	}
+	return err
+}
+
+func (r *Redis) Exists(key string) (bool, error) {
+	return redis.Bool(r.do("EXISTS", key))
+}
+
+func (r *Redis) Delete(key string) error {
+	_, err := r.do("DEL", key)
+	return err
+}
+
+func (r *Redis) IndexAdd(index, member string, score int64) error {
+	_, err := r.do("ZADD", index, score, member)
+	return err
+}
+
+func (r *Redis) IndexRemove(index, member string) error {
+	_, err := r.do("ZREM", index, member)
+	return err
+}
+
+func (r *Redis) IndexRange(index string, since, before int64, limit int) ([]string, error) {
+	max := "+inf"
+	if before != 0 {
+		max = "(" + strconv.FormatInt(before, 10)
+	}
+	min := "-inf"
+	if since != 0 {
+		min = strconv.FormatInt(since, 10)
+	}
+	return redis.Strings(r.do("ZREVRANGEBYSCORE", index, max, min, "LIMIT", 0, limit))
+}
+
+func (r *Redis) Close() error {
+	return r.pool.Close()
+}
@@ -0,0 +1,52 @@
+// Package store provides the key-value storage used by sangobox for logs,
+// the cached image list and other cache entries.
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("store: not found")
+
+// UnavailableError is returned when the backend can't be reached.
+type UnavailableError struct {
+	Err error
+}
+
+func (e UnavailableError) Error() string {
+	return "store: unavailable: " + e.Err.Error()
+}
+
+func IsUnavailable(err error) bool {
+	_, ok := err.(UnavailableError)
+	return ok
+}
+
+type Store interface {
+	// Get returns ErrNotFound if the key does not exist or has expired.
+	Get(key string) ([]byte, error)
+
+	// Set stores value under key. A zero ttl means the key never expires.
+	Set(key string, value []byte, ttl time.Duration) error
+
+	Exists(key string) (bool, error)
+	Delete(key string) error
+
+	// IndexAdd adds member to the named index, ordered by score.
+	IndexAdd(index, member string, score int64) error
+	IndexRemove(index, member string) error
+
+	// IndexRange returns at most limit members of the index, highest score
+	// first, with since <= score < before. Zero means unbounded. Members
+	// with the same score are ordered by name, highest first.
+	IndexRange(index string, since, before int64, limit int) ([]string, error)
+
+	Close() error
+}
+
+// Sweeper is implemented by backends without native TTL. Sweep removes
+// the expired entries and returns how many were removed.
+type Sweeper interface {
+	Sweep() (int, error)
+}
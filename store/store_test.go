@@ -0,0 +1,141 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testStore checks the behavior every backend must share.
+func testStore(t *testing.T, s Store) {
+	if _, err := s.Get("missing"); err != ErrNotFound {
+		t.Errorf("Get of a missing key: %v; want ErrNotFound", err)
+	}
+	if ok, err := s.Exists("missing"); ok || err != nil {
+		t.Errorf("Exists of a missing key: %v, %v", ok, err)
+	}
+
+	err := s.Set("key", []byte("value"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("key"); err != nil || string(v) != "value" {
+		t.Errorf("Get: %q, %v", v, err)
+	}
+	if ok, err := s.Exists("key"); !ok || err != nil {
+		t.Errorf("Exists: %v, %v", ok, err)
+	}
+	s.Set("key", []byte("other"), 0)
+	if v, _ := s.Get("key"); string(v) != "other" {
+		t.Errorf("Get after overwrite: %q", v)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("key"); err != ErrNotFound {
+		t.Errorf("Get after Delete: %v", err)
+	}
+	if err := s.Delete("key"); err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+
+	s.Set("expiring", []byte("value"), time.Millisecond*50)
+	s.Set("kept", []byte("value"), time.Hour)
+	if _, err := s.Get("expiring"); err != nil {
+		t.Errorf("Get before expiry: %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if _, err := s.Get("expiring"); err != ErrNotFound {
+		t.Errorf("Get after expiry: %v", err)
+	}
+	if ok, _ := s.Exists("expiring"); ok {
+		t.Error("Exists after expiry")
+	}
+	if _, err := s.Get("kept"); err != nil {
+		t.Errorf("Get of a key that hasn't expired: %v", err)
+	}
+
+	s.IndexAdd("logs", "a", 100)
+	s.IndexAdd("logs", "b", 300)
+	s.IndexAdd("logs", "c", 200)
+	s.IndexAdd("logs", "d", 200)
+	s.IndexAdd("logs", "e", -50)
+	s.IndexAdd("logs/env", "x", 150)
+
+	ranges := []struct {
+		since, before int64
+		limit         int
+		want          []string
+	}{
+		{0, 0, 10, []string{"b", "d", "c", "a", "e"}},
+		{0, 0, 2, []string{"b", "d"}},
+		{200, 0, 10, []string{"b", "d", "c"}},
+		{0, 200, 10, []string{"a", "e"}},
+		{100, 300, 10, []string{"d", "c", "a"}},
+		{-100, 100, 10, []string{"e"}},
+		{0, 201, 1, []string{"d"}},
+		{400, 0, 10, nil},
+	}
+	for _, r := range ranges {
+		got, err := s.IndexRange("logs", r.since, r.before, r.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(r.want) || (len(got) > 0 && !reflect.DeepEqual(got, r.want)) {
+			t.Errorf("IndexRange(%d, %d, %d) = %v; want %v", r.since, r.before, r.limit, got, r.want)
+		}
+	}
+
+	// Adding a member again moves it.
+	s.IndexAdd("logs", "a", 400)
+	if got, _ := s.IndexRange("logs", 0, 0, 2); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("after moving a: %v", got)
+	}
+	s.IndexRemove("logs", "a")
+	s.IndexRemove("logs", "missing")
+	if got, _ := s.IndexRange("logs", 0, 0, 10); !reflect.DeepEqual(got, []string{"b", "d", "c", "e"}) {
+		t.Errorf("after removing a: %v", got)
+	}
+	if got, _ := s.IndexRange("logs/env", 0, 0, 10); !reflect.DeepEqual(got, []string{"x"}) {
+		t.Errorf("other index: %v", got)
+	}
+	if got, _ := s.IndexRange("missing", 0, 0, 10); len(got) != 0 {
+		t.Errorf("missing index: %v", got)
+	}
+
+	if sw, ok := s.(Sweeper); ok {
+		n, err := sw.Sweep()
+		if err != nil || n != 1 {
+			t.Errorf("Sweep: %d, %v; want 1", n, err)
+		}
+		if _, err := s.Get("kept"); err != nil {
+			t.Errorf("Get after Sweep: %v", err)
+		}
+		if got, _ := s.IndexRange("logs", 0, 0, 10); len(got) != 4 {
+			t.Errorf("Sweep removed index entries: %v", got)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestLevelDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sango-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewLevelDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"log"
+
+	"github.com/h2so5/sango/src"
+)
+
+func main() {
+	a, err := sango.LoadGenericAgent(sango.ImageConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sango.Run(a)
+}
@@ -25,6 +25,7 @@ func main() {
 
 	var out sango.ExecResult
 	out.Command = strings.Join(args, " ")
+	out.TimeLimit = timeout.Seconds()
 	var stdout, stderr bytes.Buffer
 	msgStdout := sango.MsgpackFilter{Writer: os.Stderr, Tag: *prefix + "stdout"}
 	msgStderr := sango.MsgpackFilter{Writer: os.Stderr, Tag: *prefix + "stderr"}